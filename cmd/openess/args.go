@@ -12,16 +12,21 @@ type Args struct {
 	DeviceAddr  *string
 	ConfPath    string
 	Interactive bool
+	Command     []string
 }
 
 func helpMessage() string {
 	var builder strings.Builder
-	fmt.Fprintf(&builder, "Usage: %s [OPTIONS] \n", os.Args[0])
+	fmt.Fprintf(&builder, "Usage: %s [OPTIONS] [COMMAND]\n", os.Args[0])
 	fmt.Fprintln(&builder, "Options:")
 	fmt.Fprintln(&builder, "\t-l, --log\t\t logging level: off, warn, info, debug (default off)")
 	fmt.Fprintln(&builder, "\t-d, --device\t\t datalogger IP address (overrides address from config)")
 	fmt.Fprintln(&builder, "\t-c, --config\t path to the config file (default 'data/config.json')")
 	fmt.Fprintln(&builder, "\t-b, --background\t run in background, otherwise starts interactive shell")
+	fmt.Fprintln(&builder, "Commands:")
+	fmt.Fprintln(&builder, "\tdescriptor lint FILE...\t check descriptor files for errors")
+	fmt.Fprintln(&builder, "\thistory [NAME] [--since 24h] [--until TIME] [--step 5m] [--csv]")
+	fmt.Fprintln(&builder, "\t\t\t\t print recorded register values (or list recorded registers without NAME)")
 
 	return builder.String()
 }
@@ -46,6 +51,12 @@ func ParseArgs(args []string) Args {
 			os.Exit(0)
 		}
 
+		// arguments following the command are command arguments
+		if key == "" && (len(parsed.Command) != 0 || !strings.HasPrefix(arg, "-")) {
+			parsed.Command = append(parsed.Command, arg)
+			continue
+		}
+
 		if key == "" {
 			key = arg
 			continue
@@ -66,6 +66,7 @@ func InteractiveMain(args Args) {
 		LocalPort:  config.BindPort,
 		ProtoPath:  config.ProtoPath,
 		Protocol:   config.Protocol,
+		Language:   config.Language,
 	}
 
 	if args.DeviceAddr != nil {
@@ -156,8 +157,12 @@ func InteractiveMain(args Args) {
 		case "read-named":
 			name := args[1]
 			desc := cli.GetDescriptor()
-			seg, reg := desc.FindRegister(name)
-			req := commands.NewRegReadDescr(seg, reg)
+			loc, err := desc.ResolveRegister(name)
+			if err != nil {
+				fmt.Printf("error: %s\n", err)
+				continue
+			}
+			req := commands.NewRegReadDescr(loc.Segment, loc.Register)
 			resp, err := client.SendCommand(cli, req)
 			if err != nil {
 				fmt.Printf("request failed: %s\n", err)
@@ -191,9 +196,9 @@ func InteractiveMain(args Args) {
 				}
 				for addr, v := range resp.Values {
 					name := ""
-					reg := desc.FindRegisterByAddr(addr)
+					reg := desc.FindRegisterByAddrFun(seg.FunNumber, addr)
 					if reg != nil {
-						name = reg.Title["base"]
+						name = reg.Name(desc.Language)
 					}
 					fmt.Printf("[%d] %s = %s\n", addr, name, v.ToString())
 				}
@@ -236,8 +241,12 @@ func InteractiveMain(args Args) {
 			name := args[1]
 			value, _ := strconv.ParseFloat(args[2], 32)
 			desc := cli.GetDescriptor()
-			seg, reg := desc.FindRegister(name)
-			req := commands.NewRegWriteDescr(seg, reg, float32(value))
+			loc, err := desc.ResolveRegister(name)
+			if err != nil {
+				fmt.Printf("error: %s\n", err)
+				continue
+			}
+			req := commands.NewRegWriteDescr(loc.Segment, loc.Register, float32(value))
 			resp, err := client.SendCommand(cli, req)
 			if err != nil {
 				fmt.Printf("request failed: %s\n", err)
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"openess/internal/protocol"
+	"os"
+	"path/filepath"
+)
+
+func CommandMain(args Args) {
+	cmd := args.Command
+
+	switch {
+	case len(cmd) >= 2 && cmd[0] == "descriptor" && cmd[1] == "lint":
+		os.Exit(lintDescriptors(cmd[2:]))
+	case cmd[0] == "history":
+		os.Exit(printHistory(args, cmd[1:]))
+	default:
+		fmt.Fprintf(os.Stderr, "invalid command: '%s'\n", cmd[0])
+		fmt.Fprintf(os.Stderr, helpMessage())
+		os.Exit(1)
+	}
+}
+
+func lintDescriptors(paths []string) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "no descriptor files specified")
+		return 1
+	}
+
+	status := 0
+
+	for _, path := range paths {
+		issues := protocol.LintProtocolDescriptor(path)
+
+		for _, issue := range issues {
+			fmt.Printf("%s: %s\n", filepath.Base(path), issue)
+		}
+
+		if len(issues) != 0 {
+			status = 1
+		}
+	}
+
+	return status
+}
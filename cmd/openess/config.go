@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"openess/internal/collector"
 	"openess/internal/export"
+	"openess/internal/history"
 	"io"
 	"os"
 )
@@ -13,8 +14,12 @@ type Config struct {
 	DeviceAddr string
 	ProtoPath  string
 	Protocol  *string
+	Language   string
 	Collector  collector.Config
 	Export     export.Config
+	Prometheus export.PrometheusConfig
+	Influx     export.InfluxConfig
+	History    history.Config
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -4,6 +4,7 @@ import (
 	"openess/internal/client"
 	"openess/internal/collector"
 	"openess/internal/export"
+	"openess/internal/history"
 	"openess/internal/log"
 	"os"
 )
@@ -20,6 +21,7 @@ func BackgroundMain(args Args) {
 		LocalPort:  config.BindPort,
 		ProtoPath:  config.ProtoPath,
 		Protocol:   config.Protocol,
+		Language:   config.Language,
 	}
 
     if args.DeviceAddr != nil {
@@ -37,7 +39,44 @@ func BackgroundMain(args Args) {
 		os.Exit(1)
 	}
 
-	export.StartMqttExporter(config.Export, collector)
+	exporters := []export.Exporter{}
+
+	if config.Export.Broker != "" {
+		mqttExporter, err := export.NewMqttExporter(config.Export, cli)
+		if err != nil {
+			log.PrError("openess: failed to init exporter: %s\n", err)
+			os.Exit(1)
+		}
+		exporters = append(exporters, mqttExporter)
+	}
+
+	if config.Prometheus.Enabled {
+		exporters = append(exporters, export.NewPrometheusExporter(config.Prometheus))
+	}
+
+	if config.Influx.Enabled {
+		influxExporter, err := export.NewInfluxExporter(config.Influx)
+		if err != nil {
+			log.PrError("openess: failed to init influx exporter: %s\n", err)
+			os.Exit(1)
+		}
+		exporters = append(exporters, influxExporter)
+	}
+
+	if config.History.Enabled {
+		recorder, err := history.NewRecorder(config.History)
+		if err != nil {
+			log.PrError("openess: failed to init history: %s\n", err)
+			os.Exit(1)
+		}
+		exporters = append(exporters, recorder)
+	}
+
+	err = export.StartExporters(collector, exporters)
+	if err != nil {
+		log.PrError("openess: failed to start exporters: %s\n", err)
+		os.Exit(1)
+	}
 
 	select{}
 }
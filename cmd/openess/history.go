@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"openess/internal/history"
+	"os"
+	"time"
+)
+
+// Parses time argument: duration before now (e.g. 24h or 7d) or local date/time
+func parseTimeArg(arg string, now time.Time) (time.Time, error) {
+	if d, err := history.ParseDuration(arg); err == nil {
+		return now.Add(-d), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, arg); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, arg, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.New(fmt.Sprintf("invalid time '%s'", arg))
+}
+
+func printHistory(args Args, cmd []string) int {
+	now := time.Now()
+
+	name := ""
+	since := now.Add(-24 * time.Hour)
+	until := now
+	step := time.Duration(0)
+	asCsv := false
+
+	for i := 0; i < len(cmd); i++ {
+		arg := cmd[i]
+
+		if arg == "--csv" {
+			asCsv = true
+			continue
+		}
+
+		if arg != "--since" && arg != "--until" && arg != "--step" {
+			if name != "" {
+				fmt.Fprintf(os.Stderr, "invalid argument: '%s'\n", arg)
+				return 1
+			}
+			name = arg
+			continue
+		}
+
+		if i+1 == len(cmd) {
+			fmt.Fprintf(os.Stderr, "missing value of %s\n", arg)
+			return 1
+		}
+
+		i++
+
+		var err error
+
+		switch arg {
+		case "--since":
+			since, err = parseTimeArg(cmd[i], now)
+		case "--until":
+			until, err = parseTimeArg(cmd[i], now)
+		case "--step":
+			step, err = history.ParseDuration(cmd[i])
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value of %s: %s\n", arg, err)
+			return 1
+		}
+	}
+
+	config, err := LoadConfig(args.ConfPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read config %s: %s\n", args.ConfPath, err)
+		return 1
+	}
+
+	if config.History.Path == "" {
+		fmt.Fprintln(os.Stderr, "history path is not configured")
+		return 1
+	}
+
+	store, err := history.OpenStore(config.History.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open history: %s\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	if name == "" {
+		ids, err := store.Ids()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read history: %s\n", err)
+			return 1
+		}
+
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+
+		return 0
+	}
+
+	samples, err := store.Query(name, since, until, step)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read history: %s\n", err)
+		return 1
+	}
+
+	if asCsv {
+		writer := csv.NewWriter(os.Stdout)
+		writer.Write([]string{"time", "value", "units"})
+
+		for _, s := range samples {
+			writer.Write([]string{s.Time.Local().Format(time.RFC3339), s.Value, s.Units})
+		}
+
+		writer.Flush()
+
+		return 0
+	}
+
+	for _, s := range samples {
+		fmt.Printf("%s  %s%s\n", s.Time.Local().Format("2006-01-02 15:04:05"), s.Value, s.Units)
+	}
+
+	return 0
+}
@@ -7,7 +7,9 @@ import (
 func main() {
 	args := ParseArgs(os.Args)
 
-	if args.Interactive {
+	if len(args.Command) != 0 {
+		CommandMain(args)
+	} else if args.Interactive {
 		InteractiveMain(args)
 	} else {
 		BackgroundMain(args)
@@ -19,6 +19,7 @@ type Config struct {
 	DeviceAddr string
 	ProtoPath  string
 	Protocol  *string
+	Language   string
 }
 
 type Response struct {
@@ -33,6 +34,7 @@ type clientTask struct {
 	txResp          chan Response
 	descMtx         *sync.Mutex
 	desc            **protocol.Descriptor
+	info            **commands.DeviceInfoResult
 	isConnectedCond *sync.Cond
 	isConnected     *bool
 }
@@ -43,6 +45,7 @@ type Client struct {
 	rxResp          chan Response
 	descMtx         *sync.Mutex
 	desc            **protocol.Descriptor
+	info            **commands.DeviceInfoResult
 	isConnectedCond *sync.Cond
 	isConnected     *bool
 }
@@ -52,6 +55,7 @@ func StartClient(config Config) *Client {
 	resp := make(chan Response)
 
 	var desc *protocol.Descriptor
+	var info *commands.DeviceInfoResult
 
 	var descMtx = new(sync.Mutex)
 	var isConnectedCond = sync.NewCond(new(sync.Mutex))
@@ -63,6 +67,7 @@ func StartClient(config Config) *Client {
 		rxResp:          resp,
 		descMtx:         descMtx,
 		desc:            &desc,
+		info:            &info,
 		isConnectedCond: isConnectedCond,
 		isConnected:     isConnected,
 	}
@@ -74,6 +79,7 @@ func StartClient(config Config) *Client {
 		txResp:          resp,
 		descMtx:         descMtx,
 		desc:            &desc,
+		info:            &info,
 		isConnectedCond: isConnectedCond,
 		isConnected:     isConnected,
 	}
@@ -89,6 +95,13 @@ func (this *Client) GetDescriptor() *protocol.Descriptor {
 	return *this.desc
 }
 
+// Returns datalogger info received on connection, nil if not connected yet
+func (this *Client) GetDeviceInfo() *commands.DeviceInfoResult {
+	this.descMtx.Lock()
+	defer this.descMtx.Unlock()
+	return *this.info
+}
+
 func (this *Client) IsConnected() bool {
     this.isConnectedCond.L.Lock()
 	defer this.isConnectedCond.L.Unlock()
@@ -157,6 +170,8 @@ func (task *clientTask) connect() error {
 	descriptor, err := protocol.LoadProtocolDescriptor(protocolFilePath)
 	if err != nil {
 		log.PrError("failed to load protocol descriptor: %s\n", err)
+	} else {
+		descriptor.Language = task.config.Language
 	}
 
 	log.PrInfo("client: loaded protocol descriptor: %s\n", protocolFilePath)
@@ -165,6 +180,7 @@ func (task *clientTask) connect() error {
 
 	task.descMtx.Lock()
 	*task.desc = descriptor
+	*task.info = &infoRes
 	task.descMtx.Unlock()
 
     task.isConnectedCond.L.Lock()
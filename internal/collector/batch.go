@@ -0,0 +1,178 @@
+package collector
+
+import (
+	"bytes"
+	"openess/internal/client"
+	"openess/internal/commands"
+	"openess/internal/log"
+	"openess/internal/protocol"
+	"sort"
+)
+
+const DefaultMaxReadLength = 32
+
+// Registers read with a single request. Ids are export ids of the registers
+// ordered by address, Start is the wire address of the block.
+type readRange struct {
+	FunNumber byte
+	Start     uint16
+	Length    uint16
+	Ids       []string
+}
+
+type plannedRegister struct {
+	exportId  string
+	funNumber byte
+	wireAddr  uint32
+	length    uint32
+}
+
+func registerLength(reg *protocol.Register) uint32 {
+	if reg.Length != nil {
+		return uint32(*reg.Length)
+	}
+	return 1
+}
+
+// Groups registers into minimal continuous ranges sharing a function code.
+// Ranges are not longer than maxLength words unless a single register is longer.
+// Registers which can't be read in batch get a range of their own, single read
+// will report the error.
+func planReads(desc *protocol.Descriptor, state PollState, ids []string, maxLength uint16) []readRange {
+	regs := []plannedRegister{}
+	ranges := []readRange{}
+
+	for _, exportId := range ids {
+		entry := state[exportId]
+
+		reg := plannedRegister{exportId: exportId, length: registerLength(entry.Register)}
+
+		if entry.Register.FunNumber != nil {
+			reg.funNumber = *entry.Register.FunNumber
+		} else if entry.Segment != nil {
+			reg.funNumber = entry.Segment.FunNumber
+		} else {
+			ranges = append(ranges, readRange{Ids: []string{exportId}})
+			continue
+		}
+
+		if commands.CheckValueType(entry.Register) != nil {
+			ranges = append(ranges, readRange{Ids: []string{exportId}})
+			continue
+		}
+
+		wireAddr, err := desc.WireAddress(entry.Register.Address)
+		if err != nil {
+			ranges = append(ranges, readRange{Ids: []string{exportId}})
+			continue
+		}
+
+		reg.wireAddr = uint32(wireAddr)
+		regs = append(regs, reg)
+	}
+
+	sort.SliceStable(regs, func(i, j int) bool {
+		if regs[i].funNumber != regs[j].funNumber {
+			return regs[i].funNumber < regs[j].funNumber
+		}
+		return regs[i].wireAddr < regs[j].wireAddr
+	})
+
+	batched := []readRange{}
+
+	for _, reg := range regs {
+		if len(batched) > 0 {
+			last := &batched[len(batched)-1]
+			start := uint32(last.Start)
+			end := start + uint32(last.Length)
+			regEnd := reg.wireAddr + reg.length
+
+			if last.FunNumber == reg.funNumber && reg.wireAddr <= end && regEnd-start <= uint32(maxLength) {
+				if regEnd > end {
+					last.Length = uint16(regEnd - start)
+				}
+				last.Ids = append(last.Ids, reg.exportId)
+				continue
+			}
+		}
+
+		batched = append(batched, readRange{
+			FunNumber: reg.funNumber,
+			Start:     uint16(reg.wireAddr),
+			Length:    uint16(reg.length),
+			Ids:       []string{reg.exportId},
+		})
+	}
+
+	return append(batched, ranges...)
+}
+
+// Reads the range with a single request, falls back to reading registers
+// one by one if the range can't be read.
+func (this *collectorTask) pollRange(rng *readRange) {
+	if len(rng.Ids) == 1 {
+		this.pollRegister(rng.Ids[0])
+		return
+	}
+
+	desc := this.client.GetDescriptor()
+	log.PrDebug("collector: polling %d registers at %d:%d\n", len(rng.Ids), rng.Start, rng.Length)
+
+	cmd := commands.NewRegReadRaw(byte(desc.Configuration.DevAddrs[0]), rng.FunNumber, rng.Start, rng.Length)
+
+	resp, err := client.SendCommand(this.client, cmd)
+	if err != nil {
+		log.PrError("collector: failed to read registers %d:%d: %s, falling back to single reads\n",
+			rng.Start, rng.Length, err)
+
+		for _, exportId := range rng.Ids {
+			this.pollRegister(exportId)
+		}
+		return
+	}
+
+	for _, exportId := range rng.Ids {
+		regState := this.state[exportId]
+
+		value, ok := decodeRangeValue(desc, rng, resp.Data, regState.Register)
+		if !ok {
+			log.PrError("collector: register %s is missing in response, reading it alone\n", exportId)
+			this.pollRegister(exportId)
+			continue
+		}
+
+		regState.setValue(value)
+	}
+}
+
+func decodeRangeValue(desc *protocol.Descriptor, rng *readRange, data []byte, reg *protocol.Register) (commands.RegValue, bool) {
+	wireAddr, err := desc.WireAddress(reg.Address)
+	if err != nil || wireAddr < rng.Start {
+		return commands.RegValue{}, false
+	}
+
+	offset := int(wireAddr-rng.Start) * 2
+	end := offset + int(registerLength(reg))*2
+
+	if end > len(data) {
+		return commands.RegValue{}, false
+	}
+
+	return commands.NewRegValueFromBytes(bytes.NewBuffer(data[offset:end]), reg, desc), true
+}
+
+func (this *collectorTask) pollRegister(exportId string) {
+	regState := this.state[exportId]
+	log.PrDebug("collector: polling register %s\n", exportId)
+
+	cmd := commands.NewRegReadDescr(regState.Segment, regState.Register)
+
+	resp, err := client.SendCommand(this.client, cmd)
+	if err != nil {
+		log.PrError("collector: failed to read register: %s\n", err)
+		regState.setError(err)
+		return
+	}
+
+	regState.setValue(resp.Value)
+}
@@ -2,38 +2,87 @@ package collector
 
 import (
 	"errors"
+	"fmt"
 	"openess/internal/client"
 	"openess/internal/commands"
 	"openess/internal/log"
 	"openess/internal/protocol"
+	"strings"
 	"time"
 )
 
 type Config struct {
 	Enabled   bool
 	Interval  string
-	Registers map[string]string
+	Registers map[string]RegisterConfig
+	Flows     bool
+	LoopCMDs  bool
+	// Max number of registers read with a single request, 0 means default
+	MaxReadLength uint16
 }
 
 type PolledRegister struct {
 	Segment   *protocol.Segment
 	Register  *protocol.Register
 	LastValue *commands.RegValue
+	// Time of the last successful read
+	LastUpdate time.Time
+	// Error of the last read, nil if it succeeded
+	LastError error
+	// Number of failed reads
+	ErrorCount uint64
+	// Register was polled by the pass the state was sent after. States are sent after
+	// passes of every cadence, registers of other cadences are sent unchanged.
+	Refreshed bool
+}
+
+// Returns the function code the register is read with, 0 if it's unknown
+func (this *PolledRegister) funNumber() byte {
+	if this.Register.FunNumber != nil {
+		return *this.Register.FunNumber
+	}
+	if this.Segment != nil {
+		return this.Segment.FunNumber
+	}
+	return 0
+}
+
+// Register of a loop block: its address along with the function code of the block, as
+// registers of different function codes may share addresses (e.g. in 0975)
+type loopKey struct {
+	funNumber byte
+	addr      uint16
+}
+
+func (this loopKey) inBlock(block *protocol.RegisterBlock) bool {
+	return this.funNumber == block.FunNumber && this.addr >= block.StartAddress &&
+		uint32(this.addr) < uint32(block.StartAddress)+uint32(block.Length)
+}
+
+func (this *PolledRegister) setValue(value commands.RegValue) {
+	this.LastValue = &value
+	this.LastUpdate = time.Now()
+	this.LastError = nil
+}
+
+func (this *PolledRegister) setError(err error) {
+	this.LastError = err
+	this.ErrorCount += 1
 }
 
 type PollState = map[string]*PolledRegister
 
 type collectorTask struct {
-	pollInterval time.Duration
 	client       *client.Client
 	state        PollState
-	txState      chan PollState
-	txConn       chan bool
+	flowInfo     *protocol.FlowInfo
+	loopIds      map[loopKey]string
+	cadences     []*cadence
+	subs         *subscriptions
 }
 
 type Collector struct {
-	rxState chan PollState
-	rxConn  chan bool
+	subs *subscriptions
 }
 
 func StartCollector(client *client.Client, config Config) (*Collector, error) {
@@ -51,12 +100,11 @@ func StartCollector(client *client.Client, config Config) (*Collector, error) {
 	}
 
 	values := make(map[string]*PolledRegister)
-	ch := make(chan map[string]*PolledRegister)
-	cch := make(chan bool)
+	subs := &subscriptions{}
 
 	if !config.Enabled {
 		this := Collector{
-			rxState: ch,
+			subs: subs,
 		}
 
 		log.PrInfo("collector: collector is disabled, doing nothing\n")
@@ -65,92 +113,224 @@ func StartCollector(client *client.Client, config Config) (*Collector, error) {
 	}
 
 	for exportId := range config.Registers {
-		name := config.Registers[exportId]
-		seg, reg := descriptor.FindRegister(name)
+		name := config.Registers[exportId].Name
+		loc, err := descriptor.ResolveRegister(name)
 
-		if seg == nil || reg == nil {
-			log.PrError("collector: failed to find register %s, skipping from polling\n", name)
+		if err != nil {
+			log.PrError("collector: failed to resolve register %s: %s, skipping from polling\n", name, err)
 			continue
 		}
 
 		entry := PolledRegister{
-			Segment:   seg,
-			Register:  reg,
+			Segment:   loc.Segment,
+			Register:  loc.Register,
 			LastValue: nil,
 		}
 
 		values[exportId] = &entry
 	}
 
+	loopCMDs := descriptor.Configuration.LoopCMDs
+	useLoop := config.LoopCMDs || (len(config.Registers) == 0 && len(loopCMDs) > 0)
+
+	if useLoop && len(loopCMDs) == 0 {
+		log.PrError("collector: descriptor has no LoopCMDs, falling back to configured registers\n")
+		useLoop = false
+	}
+
+	var loopIds map[loopKey]string
+
+	if useLoop {
+		log.PrInfo("collector: polling %d register blocks from descriptor LoopCMDs\n", len(loopCMDs))
+		loopIds = buildLoopPlan(descriptor, loopCMDs, values)
+	} else {
+		loopCMDs = nil
+	}
+
+	maxReadLength := config.MaxReadLength
+	if maxReadLength == 0 {
+		maxReadLength = DefaultMaxReadLength
+	}
+
+	cadences, err := planCadences(descriptor, config, pollInterval, values, loopIds, maxReadLength)
+	if err != nil {
+		return nil, err
+	}
+
+	cadences[0].loopBlocks = loopCMDs
+
+	for _, c := range cadences {
+		log.PrInfo("collector: polling %d requests every %s with priority %d\n", c.steps(), c.interval, c.priority)
+	}
+
+	if config.Flows {
+		if descriptor.Configuration.FlowInfoVC == nil {
+			log.PrError("collector: descriptor has no energy flow info, flows will not be polled\n")
+		} else {
+			cadences[0].flows = true
+			checkFlowInfo(descriptor, descriptor.Configuration.FlowInfoVC)
+		}
+	}
+
 	task := collectorTask{
-		pollInterval: pollInterval,
 		client:       client,
 		state:        values,
-		txState:      ch,
-		txConn:       cch,
+		flowInfo:     descriptor.Configuration.FlowInfoVC,
+		loopIds:      loopIds,
+		cadences:     cadences,
+		subs:         subs,
 	}
 
 	go task.pollLoop()
 
 	collector := Collector{
-		rxState: ch,
-		rxConn:  cch,
+		subs: subs,
 	}
 
 	return &collector, err
 }
 
-func (this *Collector) GetStateChan() chan PollState {
-	return this.rxState
+// Adds every register found in the blocks to the state. Registers already listed in
+// the state keep their export id, other ones are exported by their descriptor name.
+// Returns export ids of the block registers keyed by function code and address.
+func buildLoopPlan(desc *protocol.Descriptor, blocks []protocol.RegisterBlock, state PollState) map[loopKey]string {
+	ids := make(map[loopKey]string)
+
+	for exportId, entry := range state {
+		ids[loopKey{funNumber: entry.funNumber(), addr: entry.Register.Address}] = exportId
+	}
+
+	loopIds := make(map[loopKey]string)
+
+	for _, block := range blocks {
+		for _, reg := range desc.BlockRegisters(block) {
+			key := loopKey{funNumber: block.FunNumber, addr: reg.Address}
+			if _, ok := loopIds[key]; ok {
+				continue
+			}
+
+			exportId, ok := ids[key]
+			if !ok {
+				exportId = registerExportId(reg.Name("base"))
+				if _, exists := state[exportId]; exists || exportId == "" {
+					exportId = fmt.Sprintf("%s_%d", exportId, reg.Address)
+				}
+
+				// the block is the segment of registers read only by LoopCMDs
+				segment := protocol.Segment{StartAddress: block.StartAddress, Length: block.Length, FunNumber: block.FunNumber}
+				state[exportId] = &PolledRegister{Segment: &segment, Register: reg}
+			}
+
+			loopIds[key] = exportId
+		}
+	}
+
+	return loopIds
+}
+
+// Converts register title to a topic friendly id, e.g. "AC output Load %" -> "ac_output_load"
+func registerExportId(name string) string {
+	id := strings.Builder{}
+	sep := false
+
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			if sep && id.Len() > 0 {
+				id.WriteByte('_')
+			}
+			id.WriteRune(c)
+			sep = false
+		} else {
+			sep = true
+		}
+	}
+
+	return id.String()
 }
 
-func (this *Collector) GetConnChan() chan bool {
-	return this.rxConn
+// Notifies exporters about datalogger connection state change
+func (this *collectorTask) setConnected(connected bool) {
+	this.subs.sendConn(connected)
+}
+
+func (this *collectorTask) pollBlock(block *protocol.RegisterBlock) {
+	log.PrDebug("collector: polling block %d:%d\n", block.StartAddress, block.Length)
+
+	resp, err := client.SendCommand(this.client, commands.NewRegReadBlock(block))
+	if err != nil {
+		log.PrError("collector: failed to read block %d:%d: %s\n", block.StartAddress, block.Length, err)
+
+		for key, exportId := range this.loopIds {
+			if key.inBlock(block) {
+				this.state[exportId].setError(err)
+			}
+		}
+		return
+	}
+
+	for addr, value := range resp.Values {
+		exportId, ok := this.loopIds[loopKey{funNumber: block.FunNumber, addr: addr}]
+		if !ok {
+			continue
+		}
+
+		this.state[exportId].setValue(value)
+	}
 }
 
 func (this *collectorTask) pollLoop() {
-	timer := time.NewTimer(this.pollInterval)
 	firstPoll := true
 
+	start := time.Now()
+	for _, c := range this.cadences {
+		c.next = start.Add(c.interval)
+	}
+
 	for {
-		<-timer.C
-		timer.Reset(this.pollInterval)
+		now := time.Now()
+
+		c := nextCadence(this.cadences, now)
+		if c == nil {
+			time.Sleep(nextDueTime(this.cadences).Sub(now))
+			continue
+		}
 
 		var isOffline = !this.client.IsConnected()
 		if isOffline {
-			this.txConn <- false
+			this.setConnected(false)
 		}
 
 		log.PrDebug("collector: waiting for connection\n")
 		this.client.WaitConnection()
 
 		if isOffline || firstPoll {
-			this.txConn <- true
+			this.setConnected(true)
 		}
 
 		if firstPoll {
 		    firstPoll = false
 		}
 
-		for exportId := range this.state {
-			regState := this.state[exportId]
-			log.PrDebug("collector: polling register %s\n", exportId)
+		if !this.pollStep(c) {
+			continue
+		}
 
-			cmd := commands.NewRegReadDescr(regState.Segment, regState.Register)
+		c.reschedule(time.Now())
 
-			resp, err := client.SendCommand(this.client, cmd)
+		this.subs.sendState(this.state, this.cadenceIds(c))
+		log.PrDebug("collector: sent updated state\n")
+
+		if c.flows {
+			log.PrDebug("collector: polling energy flows\n")
+
+			flows, err := this.pollFlows()
 			if err != nil {
-				log.PrError("collector: failed to read register: %s\n", err)
+				log.PrError("collector: failed to read energy flows: %s\n", err)
 				continue
 			}
 
-			regState.LastValue = &resp.Value
-		}
-
-		select {
-		case this.txState <- this.state:
-			log.PrDebug("collector: sent updated state\n")
-		default:
+			this.subs.sendFlow(*flows)
+			log.PrDebug("collector: sent energy flows\n")
 		}
 	}
 }
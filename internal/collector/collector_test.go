@@ -0,0 +1,309 @@
+package collector
+
+import (
+	"encoding/json"
+	"openess/internal/log"
+	"openess/internal/protocol"
+	"testing"
+	"time"
+)
+
+func TestLoopPlan(t *testing.T) {
+	desc := protocol.Descriptor{
+		Root: []protocol.Register{
+			{Address: 0, Title: map[string]string{"base": "AC output Load %"}},
+			{Address: 1, Title: map[string]string{"base": "Battery voltage"}},
+			{Address: 2, Title: map[string]string{"base": "battery  Voltage"}},
+			{Address: 3, Title: map[string]string{"base": "Outside"}},
+		},
+	}
+
+	state := PollState{
+		"battery": {Register: &desc.Root[1], Segment: &protocol.Segment{FunNumber: 4, StartAddress: 1, Length: 1}},
+	}
+
+	blocks := []protocol.RegisterBlock{
+		{FunNumber: 4, StartAddress: 0, Length: 3},
+		{FunNumber: 4, StartAddress: 1, Length: 1},
+	}
+
+	ids := buildLoopPlan(&desc, blocks, state)
+
+	expected := map[uint16]string{
+		0: "ac_output_load",
+		1: "battery",
+		2: "battery_voltage",
+	}
+
+	if len(ids) != len(expected) {
+		t.Fatalf("unexpected plan: %v", ids)
+	}
+
+	for addr, id := range expected {
+		if ids[loopKey{funNumber: 4, addr: addr}] != id {
+			t.Fatalf("%d: %s != %s", addr, id, ids[loopKey{funNumber: 4, addr: addr}])
+		}
+		if state[id] == nil || state[id].Register != &desc.Root[addr] {
+			t.Fatalf("%s: register is not in state", id)
+		}
+	}
+
+	if len(state) != 3 {
+		t.Fatalf("unexpected state size %d", len(state))
+	}
+}
+
+func TestLoopPlanSharedAddresses(t *testing.T) {
+	log.Init(log.LOG_OFF)
+
+	desc, err := protocol.LoadProtocolDescriptor("../../data/0975.json")
+	if err != nil {
+		t.Fatalf("failed to load descriptor: %s", err)
+	}
+
+	state := PollState{}
+	ids := buildLoopPlan(desc, desc.Configuration.LoopCMDs, state)
+
+	// holding and input registers at 10 are exported separately
+	holding, input := ids[loopKey{funNumber: 3, addr: 10}], ids[loopKey{funNumber: 4, addr: 10}]
+	if holding != "grid_charging" || input != "device_model" {
+		t.Fatalf("unexpected ids at 10: %q %q", holding, input)
+	}
+
+	if entry := state["device_model"]; entry == nil || *entry.Register.FunNumber != 4 || entry.funNumber() != 4 {
+		t.Fatalf("unexpected device model entry %+v", entry)
+	}
+
+	if entry := state["grid_charging"]; entry == nil || entry.funNumber() != 3 {
+		t.Fatalf("unexpected grid charging entry %+v", entry)
+	}
+}
+
+func TestPlanReads(t *testing.T) {
+	two := 2
+	fun := byte(3)
+	desc := protocol.Descriptor{
+		Root: []protocol.Register{
+			{Address: 10},
+			{Address: 11, Length: &two},
+			{Address: 13},
+			{Address: 15},
+			{Address: 16},
+			{Address: 17, FunNumber: &fun},
+			{Address: 30},
+			{Address: 14, ValueType: 14},
+		},
+	}
+	input := protocol.Segment{FunNumber: 4, StartAddress: 10, Length: 10}
+
+	state := PollState{
+		"a": {Segment: &input, Register: &desc.Root[0]},
+		"b": {Segment: &input, Register: &desc.Root[1]},
+		"c": {Segment: &input, Register: &desc.Root[2]},
+		"d": {Segment: &input, Register: &desc.Root[3]},
+		"e": {Segment: &input, Register: &desc.Root[4]},
+		"f": {Segment: &input, Register: &desc.Root[5]},
+		"g": {Register: &desc.Root[6]},
+		"h": {Segment: &input, Register: &desc.Root[7]},
+	}
+
+	// registers of unsupported types are read alone to report the error
+	ranges := planReads(&desc, state, []string{"e", "d", "c", "b", "a", "f", "g", "h"}, 4)
+
+	expected := []readRange{
+		{FunNumber: 3, Start: 17, Length: 1, Ids: []string{"f"}},
+		{FunNumber: 4, Start: 10, Length: 4, Ids: []string{"a", "b", "c"}},
+		{FunNumber: 4, Start: 15, Length: 2, Ids: []string{"d", "e"}},
+		{Ids: []string{"g"}},
+		{Ids: []string{"h"}},
+	}
+
+	if len(ranges) != len(expected) {
+		t.Fatalf("unexpected plan: %+v", ranges)
+	}
+
+	for i := range expected {
+		r, e := ranges[i], expected[i]
+		if r.FunNumber != e.FunNumber || r.Start != e.Start || r.Length != e.Length || len(r.Ids) != len(e.Ids) {
+			t.Fatalf("%d: %+v != %+v", i, e, r)
+		}
+		for j := range e.Ids {
+			if r.Ids[j] != e.Ids[j] {
+				t.Fatalf("%d: %+v != %+v", i, e, r)
+			}
+		}
+	}
+
+	data := []byte{0, 1, 0, 2, 0, 3, 0, 4}
+	value, ok := decodeRangeValue(&desc, &ranges[1], data, &desc.Root[1])
+	if !ok || value.ValueRaw != 0x00030002 {
+		t.Fatalf("unexpected value %+v", value)
+	}
+
+	if _, ok := decodeRangeValue(&desc, &ranges[2], data[:2], &desc.Root[4]); ok {
+		t.Fatalf("expected truncated register")
+	}
+}
+
+func TestRegisterConfig(t *testing.T) {
+	var registers map[string]RegisterConfig
+
+	data := `{"power": "Output active power", "temp": {"Name": "Temperature", "Interval": "1m", "Priority": -1}}`
+	if err := json.Unmarshal([]byte(data), &registers); err != nil {
+		t.Fatalf("failed to parse registers: %s", err)
+	}
+
+	if registers["power"] != (RegisterConfig{Name: "Output active power"}) {
+		t.Fatalf("unexpected config %+v", registers["power"])
+	}
+
+	if registers["temp"] != (RegisterConfig{Name: "Temperature", Interval: "1m", Priority: -1}) {
+		t.Fatalf("unexpected config %+v", registers["temp"])
+	}
+}
+
+func TestPlanCadences(t *testing.T) {
+	desc := protocol.Descriptor{
+		Root: []protocol.Register{{Address: 1}, {Address: 2}, {Address: 3}},
+	}
+	seg := protocol.Segment{FunNumber: 4, StartAddress: 1, Length: 3}
+
+	state := PollState{
+		"a": {Segment: &seg, Register: &desc.Root[0]},
+		"b": {Segment: &seg, Register: &desc.Root[1]},
+		"c": {Segment: &seg, Register: &desc.Root[2]},
+	}
+
+	config := Config{
+		Registers: map[string]RegisterConfig{
+			"a": {Name: "a"},
+			"b": {Name: "b", Interval: "10s", Priority: 1},
+			"c": {Name: "c", Interval: "10s", Priority: 1},
+		},
+	}
+
+	cadences, err := planCadences(&desc, config, time.Second, state, nil, 32)
+	if err != nil {
+		t.Fatalf("failed to plan: %s", err)
+	}
+
+	if len(cadences) != 2 || cadences[0].interval != time.Second || cadences[1].interval != 10*time.Second {
+		t.Fatalf("unexpected cadences %+v", cadences)
+	}
+
+	if len(cadences[1].readPlan) != 1 || len(cadences[1].readPlan[0].Ids) != 2 || cadences[1].priority != 1 {
+		t.Fatalf("unexpected slow cadence %+v", cadences[1])
+	}
+
+	config.Registers["a"] = RegisterConfig{Name: "a", Interval: "0s"}
+	if _, err := planCadences(&desc, config, time.Second, state, nil, 32); err == nil {
+		t.Fatalf("expected an error for zero interval")
+	}
+}
+
+func TestNextCadence(t *testing.T) {
+	now := time.Now()
+
+	fast := &cadence{interval: time.Second, next: now}
+	slow := &cadence{interval: time.Minute, next: now.Add(-time.Second)}
+	urgent := &cadence{interval: time.Minute, priority: 1, next: now.Add(500 * time.Millisecond)}
+	cadences := []*cadence{slow, urgent, fast}
+
+	if c := nextCadence(cadences, now); c != fast {
+		t.Fatalf("expected fast cadence, got %+v", c)
+	}
+
+	if c := nextCadence(cadences, now.Add(500*time.Millisecond)); c != urgent {
+		t.Fatalf("expected urgent cadence, got %+v", c)
+	}
+
+	// slow cadence waited for more than its interval
+	if c := nextCadence(cadences, now.Add(2*time.Minute)); c != slow {
+		t.Fatalf("expected starving cadence, got %+v", c)
+	}
+
+	if c := nextCadence(cadences, now.Add(-time.Hour)); c != nil {
+		t.Fatalf("expected nothing to be due, got %+v", c)
+	}
+
+	if next := nextDueTime(cadences); !next.Equal(slow.next) {
+		t.Fatalf("unexpected next due time %s", next)
+	}
+
+	fast.reschedule(now.Add(time.Hour))
+	if !fast.next.Equal(now.Add(time.Hour)) || fast.step != 0 {
+		t.Fatalf("unexpected reschedule result %+v", fast)
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	log.Init(log.LOG_OFF)
+
+	col := Collector{subs: &subscriptions{}}
+
+	fast := col.Subscribe(2)
+	slow := col.Subscribe(2)
+
+	reg := protocol.Register{Address: 1}
+	state := PollState{"a": {Register: &reg}}
+
+	for i := 1; i <= 3; i++ {
+		state["a"].ErrorCount = uint64(i)
+		col.subs.sendState(state, map[string]bool{"a": i != 2})
+
+		if i == 1 {
+			if s := <-fast.State; s["a"].ErrorCount != 1 {
+				t.Fatalf("unexpected state %+v", s["a"])
+			}
+		}
+	}
+
+	col.subs.sendConn(false)
+
+	for _, expected := range []uint64{2, 3} {
+		s := <-slow.State
+		if s["a"].ErrorCount != expected {
+			t.Fatalf("%d != %d", expected, s["a"].ErrorCount)
+		}
+		if s["a"] == state["a"] {
+			t.Fatalf("subscriber got collector state instead of a copy")
+		}
+		if s["a"].Refreshed != (expected == 3) {
+			t.Fatalf("unexpected refreshed mark %+v", s["a"])
+		}
+	}
+
+	if s := <-fast.State; s["a"].ErrorCount != 2 {
+		t.Fatalf("unexpected state %+v", s["a"])
+	}
+
+	if !(<-fast.Conn == false && <-slow.Conn == false) {
+		t.Fatalf("expected connection events")
+	}
+
+	col.subs.sendConn(true)
+
+	if late := col.Subscribe(2); len(late.Conn) != 1 || <-late.Conn != true {
+		t.Fatalf("late subscriber didn't get the current connection state")
+	}
+}
+
+func TestCadenceIds(t *testing.T) {
+	task := collectorTask{
+		loopIds: map[loopKey]string{
+			{funNumber: 4, addr: 10}: "voltage",
+			{funNumber: 3, addr: 10}: "mode",
+			{funNumber: 4, addr: 20}: "outside",
+		},
+	}
+
+	c := cadence{
+		loopBlocks: []protocol.RegisterBlock{{FunNumber: 4, StartAddress: 10, Length: 5}},
+		readPlan:   []readRange{{Ids: []string{"temp", "power"}}},
+	}
+
+	ids := task.cadenceIds(&c)
+	if len(ids) != 3 || !ids["voltage"] || !ids["temp"] || !ids["power"] {
+		t.Fatalf("unexpected cadence ids %v", ids)
+	}
+}
@@ -0,0 +1,220 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"openess/internal/client"
+	"openess/internal/commands"
+	"openess/internal/log"
+	"openess/internal/protocol"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type FlowValue struct {
+	Value string `json:"value"`
+	Units string `json:"units"`
+}
+
+// Energy line of the flow diagram between the inverter and a pv/grid/load/battery/generator node
+type FlowLine struct {
+	// Line register is not zero
+	Active bool `json:"active"`
+	// Value shown next to the node of the line (noteValues), usually power
+	Value *FlowValue `json:"value,omitempty"`
+}
+
+// Energy flow snapshot built from FlowInfoVC section of the descriptor.
+// Flows are the energy lines of the diagram (e.g. pv_to_inverter), statuses are the other
+// flow registers (e.g. battery_status) with raw values, infos are the values shown in
+// pv/grid/load/battery/system node details.
+type FlowState struct {
+	Flows    map[string]FlowLine             `json:"flows"`
+	Statuses map[string]int                  `json:"statuses"`
+	Infos    map[string]map[string]FlowValue `json:"infos"`
+}
+
+type blockReader = func(blocks []protocol.RegisterBlock) ([][]byte, error)
+
+func (this *collectorTask) readBlocks(blocks []protocol.RegisterBlock) ([][]byte, error) {
+	results := [][]byte{}
+
+	for i := range blocks {
+		cmd := commands.NewRegReadBlock(&blocks[i])
+
+		resp, err := client.SendCommand(this.client, cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, resp.Data)
+	}
+
+	return results, nil
+}
+
+func (this *collectorTask) pollFlows() (*FlowState, error) {
+	return buildFlowState(this.client.GetDescriptor(), this.flowInfo, this.readBlocks)
+}
+
+// Returns the node of an energy line, e.g. "pv" for pv_to_inverter, or false if the
+// flow is not a line (e.g. battery_status)
+func lineNode(name string) (string, bool) {
+	from, to, ok := strings.Cut(name, "_to_")
+	if !ok {
+		return "", false
+	}
+
+	if from == "inverter" {
+		return to, true
+	}
+
+	return from, true
+}
+
+func buildFlowState(desc *protocol.Descriptor, info *protocol.FlowInfo, read blockReader) (*FlowState, error) {
+	state := FlowState{
+		Flows:    make(map[string]FlowLine),
+		Statuses: make(map[string]int),
+		Infos:    make(map[string]map[string]FlowValue),
+	}
+
+	// the first note value of a node is shown on its lines
+	notes := make(map[string]*FlowValue)
+
+	for node, blocks := range info.NoteValues {
+		results, err := read(blocks)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range blocks {
+			if values := blockValues(desc, blocks[i], results[i]); len(values) > 0 {
+				notes[node] = &values[0].FlowValue
+				break
+			}
+		}
+	}
+
+	for name, blocks := range info.Flows {
+		results, err := read(blocks)
+		if err != nil {
+			return nil, err
+		}
+
+		// flow registers are single words, the first one is used if there are several
+		value := 0
+		if len(results) > 0 && len(results[0]) >= 2 {
+			value = int(binary.BigEndian.Uint16(results[0]))
+		}
+
+		node, isLine := lineNode(name)
+		if !isLine {
+			state.Statuses[name] = value
+			continue
+		}
+
+		state.Flows[name] = FlowLine{Active: value != 0, Value: notes[node]}
+	}
+
+	for node, blocks := range info.Infos {
+		results, err := read(blocks)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[string]FlowValue)
+		for i := range blocks {
+			for _, v := range blockValues(desc, blocks[i], results[i]) {
+				values[v.name] = v.FlowValue
+			}
+		}
+
+		state.Infos[node] = values
+	}
+
+	return &state, nil
+}
+
+type namedFlowValue struct {
+	name string
+	FlowValue
+}
+
+// Decodes block data in address order. Words without a descriptor register (e.g. infos
+// of 1209) are returned as raw numbers named by their address.
+func blockValues(desc *protocol.Descriptor, block protocol.RegisterBlock, data []byte) []namedFlowValue {
+	values := []namedFlowValue{}
+	buf := bytes.NewBuffer(data)
+	addr := block.StartAddress
+
+	for buf.Len() >= 2 {
+		reg := desc.FindRegisterByAddrFun(block.FunNumber, addr)
+
+		length := 1
+		if reg != nil && reg.Length != nil {
+			length = *reg.Length
+		}
+
+		if reg == nil || buf.Len() < length*2 {
+			word := binary.BigEndian.Uint16(buf.Next(2))
+			values = append(values, namedFlowValue{
+				name:      fmt.Sprintf("register %d", addr),
+				FlowValue: FlowValue{Value: strconv.Itoa(int(word))},
+			})
+			addr += 1
+			continue
+		}
+
+		value := commands.NewRegValueFromBytes(buf, reg, desc)
+
+		units := reg.Units
+		if value.Units != nil {
+			units = *value.Units
+		}
+
+		values = append(values, namedFlowValue{
+			name:      reg.Name(desc.Language),
+			FlowValue: FlowValue{Value: value.ToStringRaw(), Units: units},
+		})
+		addr += uint16(length)
+	}
+
+	return values
+}
+
+// Warns about flow info registers missing in the descriptor, their raw values are published
+func checkFlowInfo(desc *protocol.Descriptor, info *protocol.FlowInfo) {
+	missing := []int{}
+
+	for _, section := range []map[string][]protocol.RegisterBlock{info.Infos, info.NoteValues} {
+		for _, blocks := range section {
+			for _, block := range blocks {
+				end := uint32(block.StartAddress) + uint32(block.Length)
+
+				for addr := uint32(block.StartAddress); addr < end; {
+					reg := desc.FindRegisterByAddrFun(block.FunNumber, uint16(addr))
+					if reg == nil {
+						missing = append(missing, int(addr))
+						addr += 1
+					} else if reg.Length != nil {
+						addr += uint32(*reg.Length)
+					} else {
+						addr += 1
+					}
+				}
+			}
+		}
+	}
+
+	if len(missing) == 0 {
+		return
+	}
+
+	sort.Ints(missing)
+
+	log.PrError("collector: energy flow registers %s are not in descriptor, raw values will be published\n",
+		strings.Trim(fmt.Sprint(missing), "[]"))
+}
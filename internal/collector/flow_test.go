@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"encoding/binary"
+	"openess/internal/log"
+	"openess/internal/protocol"
+	"testing"
+)
+
+// Returns a reader of register blocks with the given word values, other words are zero
+func wordReader(words map[uint16]uint16) blockReader {
+	return func(blocks []protocol.RegisterBlock) ([][]byte, error) {
+		results := [][]byte{}
+
+		for _, block := range blocks {
+			data := make([]byte, block.Length*2)
+			for i := uint16(0); i < block.Length; i++ {
+				binary.BigEndian.PutUint16(data[i*2:], words[block.StartAddress+i])
+			}
+			results = append(results, data)
+		}
+
+		return results, nil
+	}
+}
+
+func TestFlowState(t *testing.T) {
+	log.Init(log.LOG_OFF)
+
+	desc, err := protocol.LoadProtocolDescriptor("../../data/0975.json")
+	if err != nil {
+		t.Fatalf("failed to load descriptor: %s", err)
+	}
+
+	words := map[uint16]uint16{24: 1, 38: 2, 111: 230, 112: 1500, 113: 52}
+
+	state, err := buildFlowState(desc, desc.Configuration.FlowInfoVC, wordReader(words))
+	if err != nil {
+		t.Fatalf("failed to build flow state: %s", err)
+	}
+
+	pv := state.Flows["pv_to_inverter"]
+	if !pv.Active || pv.Value == nil || pv.Value.Value != "1500" || pv.Value.Units != "W" {
+		t.Fatalf("unexpected pv line %+v", pv)
+	}
+
+	if battery, ok := state.Flows["inverter_to_battery"]; !ok || battery.Active {
+		t.Fatalf("unexpected battery line %+v", battery)
+	}
+
+	if _, ok := state.Flows["battery_status"]; ok || state.Statuses["battery_status"] != 2 {
+		t.Fatalf("unexpected statuses %v", state.Statuses)
+	}
+
+	if current := state.Infos["pv"]["PV Charging Current"]; current.Value != "5.2" || current.Units != "A" {
+		t.Fatalf("unexpected pv infos %v", state.Infos["pv"])
+	}
+}
+
+func TestFlowInfosWithoutRegisters(t *testing.T) {
+	log.Init(log.LOG_OFF)
+
+	desc, err := protocol.LoadProtocolDescriptor("../../data/1209.json")
+	if err != nil {
+		t.Fatalf("failed to load descriptor: %s", err)
+	}
+
+	state, err := buildFlowState(desc, desc.Configuration.FlowInfoVC, wordReader(map[uint16]uint16{111: 230}))
+	if err != nil {
+		t.Fatalf("failed to build flow state: %s", err)
+	}
+
+	if len(state.Infos["pv"]) != 3 || state.Infos["pv"]["register 111"].Value != "230" {
+		t.Fatalf("unexpected pv infos %v", state.Infos["pv"])
+	}
+}
@@ -0,0 +1,220 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"openess/internal/log"
+	"openess/internal/protocol"
+	"sort"
+	"time"
+)
+
+// Polled register config. Can be specified either as a register name or as an object:
+// {"Name": "Output active power", "Interval": "5s", "Priority": 1}
+type RegisterConfig struct {
+	Name string
+	// Polling interval, collector interval if empty
+	Interval string
+	// Registers with higher priority are polled first when several are due
+	Priority int
+}
+
+func (this *RegisterConfig) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*this = RegisterConfig{}
+		return json.Unmarshal(data, &this.Name)
+	}
+
+	type plain RegisterConfig
+	return json.Unmarshal(data, (*plain)(this))
+}
+
+// Group of registers polled with the same interval and priority.
+// A poll pass is done step by step (one request per step), so a pass of a slow
+// cadence can be interrupted by a more urgent one.
+type cadence struct {
+	interval   time.Duration
+	priority   int
+	loopBlocks []protocol.RegisterBlock
+	readPlan   []readRange
+	flows      bool
+	next       time.Time
+	step       int
+}
+
+func (this *cadence) steps() int {
+	return len(this.loopBlocks) + len(this.readPlan)
+}
+
+func (this *cadence) isDue(now time.Time) bool {
+	return !this.next.After(now)
+}
+
+// Cadence waiting for more than its interval after it was due. Starving cadences
+// are polled before any other ones regardless of priority.
+func (this *cadence) isStarving(now time.Time) bool {
+	return now.Sub(this.next) >= this.interval
+}
+
+// Picks a due cadence to poll next: starving first (longest waiting), then by
+// priority, then by interval (faster first). Returns nil if nothing is due.
+func nextCadence(cadences []*cadence, now time.Time) *cadence {
+	var best *cadence
+
+	for _, c := range cadences {
+		if !c.isDue(now) {
+			continue
+		}
+
+		if best == nil || cadenceLess(c, best, now) {
+			best = c
+		}
+	}
+
+	return best
+}
+
+func cadenceLess(a *cadence, b *cadence, now time.Time) bool {
+	if a.isStarving(now) != b.isStarving(now) {
+		return a.isStarving(now)
+	}
+	if a.isStarving(now) {
+		return a.next.Before(b.next)
+	}
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	if a.interval != b.interval {
+		return a.interval < b.interval
+	}
+	return a.next.Before(b.next)
+}
+
+// Returns time of the earliest due cadence
+func nextDueTime(cadences []*cadence) time.Time {
+	next := cadences[0].next
+
+	for _, c := range cadences[1:] {
+		if c.next.Before(next) {
+			next = c.next
+		}
+	}
+
+	return next
+}
+
+// Schedules next pass of the cadence after the current one is complete
+func (this *cadence) reschedule(now time.Time) {
+	this.step = 0
+	this.next = this.next.Add(this.interval)
+
+	if this.next.Before(now) {
+		this.next = now
+	}
+}
+
+// Returns export ids of the registers polled by the cadence
+func (this *collectorTask) cadenceIds(c *cadence) map[string]bool {
+	ids := make(map[string]bool)
+
+	for _, rng := range c.readPlan {
+		for _, exportId := range rng.Ids {
+			ids[exportId] = true
+		}
+	}
+
+	for i := range c.loopBlocks {
+		for key, exportId := range this.loopIds {
+			if key.inBlock(&c.loopBlocks[i]) {
+				ids[exportId] = true
+			}
+		}
+	}
+
+	return ids
+}
+
+// Polls a single step of the cadence, returns true if the pass is complete
+func (this *collectorTask) pollStep(c *cadence) bool {
+	if c.step < len(c.loopBlocks) {
+		this.pollBlock(&c.loopBlocks[c.step])
+	} else if c.step < c.steps() {
+		this.pollRange(&c.readPlan[c.step-len(c.loopBlocks)])
+	}
+
+	c.step += 1
+
+	if c.step < c.steps() {
+		return false
+	}
+
+	log.PrDebug("collector: poll pass with interval %s is complete\n", c.interval)
+
+	return true
+}
+
+// Groups polled registers into cadences by their interval and priority. The first
+// cadence is the default one (collector interval, zero priority), it is always present.
+// Registers covered by loop blocks are polled with the blocks.
+func planCadences(desc *protocol.Descriptor, config Config, interval time.Duration, state PollState,
+	loopIds map[loopKey]string, maxReadLength uint16) ([]*cadence, error) {
+	type cadenceKey struct {
+		interval time.Duration
+		priority int
+	}
+
+	defaultKey := cadenceKey{interval: interval}
+	keys := []cadenceKey{defaultKey}
+	ids := map[cadenceKey][]string{}
+
+	exportIds := []string{}
+	for exportId := range state {
+		exportIds = append(exportIds, exportId)
+	}
+	sort.Strings(exportIds)
+
+	for _, exportId := range exportIds {
+		entry := state[exportId]
+		if loopIds[loopKey{funNumber: entry.funNumber(), addr: entry.Register.Address}] == exportId {
+			continue
+		}
+
+		key := defaultKey
+
+		if regConfig, ok := config.Registers[exportId]; ok {
+			key.priority = regConfig.Priority
+
+			if regConfig.Interval != "" {
+				regInterval, err := time.ParseDuration(regConfig.Interval)
+				if err != nil {
+					return nil, errors.New(fmt.Sprintf("register %s: %s", exportId, err))
+				}
+				if regInterval <= 0 {
+					return nil, errors.New(fmt.Sprintf("register %s: interval must be positive", exportId))
+				}
+				key.interval = regInterval
+			}
+		}
+
+		if _, ok := ids[key]; !ok && key != defaultKey {
+			keys = append(keys, key)
+		}
+
+		ids[key] = append(ids[key], exportId)
+	}
+
+	cadences := []*cadence{}
+
+	for _, key := range keys {
+		c := cadence{
+			interval: key.interval,
+			priority: key.priority,
+			readPlan: planReads(desc, state, ids[key], maxReadLength),
+		}
+
+		cadences = append(cadences, &c)
+	}
+
+	return cadences, nil
+}
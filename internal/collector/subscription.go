@@ -0,0 +1,115 @@
+package collector
+
+import (
+	"openess/internal/log"
+	"sync"
+)
+
+const DefaultSubscriptionBuffer = 16
+
+// Stream of collector events of a single consumer. If the consumer can't keep up and
+// the buffer is full, the oldest events are dropped. States are copies owned by
+// the collector, consumers must not modify them.
+type Subscription struct {
+	// Polled states, sent after each poll pass with the registers of the pass marked as refreshed
+	State chan PollState
+	// Datalogger connection state changes, the current state is sent on subscription if known
+	Conn chan bool
+	// Energy flow snapshots, never sent if flow polling is disabled
+	Flow chan FlowState
+}
+
+type subscriptions struct {
+	mtx  sync.Mutex
+	subs []*Subscription
+	// last sent connection state, nil before the first one
+	connected *bool
+}
+
+// Creates a new event stream with the given buffer size (DefaultSubscriptionBuffer if zero)
+func (this *Collector) Subscribe(buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultSubscriptionBuffer
+	}
+
+	sub := Subscription{
+		State: make(chan PollState, buffer),
+		Conn:  make(chan bool, buffer),
+		Flow:  make(chan FlowState, buffer),
+	}
+
+	this.subs.mtx.Lock()
+	defer this.subs.mtx.Unlock()
+
+	this.subs.subs = append(this.subs.subs, &sub)
+
+	// subscribers created after the collector has connected still learn the current state
+	if this.subs.connected != nil {
+		sub.Conn <- *this.subs.connected
+	}
+
+	return &sub
+}
+
+// Sends the value dropping the oldest one if the channel is full.
+// Must be called by the only sender of the channel.
+func sendLatest[T any](ch chan T, v T) bool {
+	dropped := false
+
+	for {
+		select {
+		case ch <- v:
+			return dropped
+		default:
+		}
+
+		select {
+		case <-ch:
+			dropped = true
+		default:
+		}
+	}
+}
+
+func (this *subscriptions) each(f func(sub *Subscription) bool, event string) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+
+	for i, sub := range this.subs {
+		if f(sub) {
+			log.PrDebug("collector: subscriber %d is too slow, dropped oldest %s\n", i, event)
+		}
+	}
+}
+
+func (this *subscriptions) sendState(state PollState, refreshed map[string]bool) {
+	copied := copyState(state)
+	for exportId, v := range copied {
+		v.Refreshed = refreshed[exportId]
+	}
+
+	this.each(func(sub *Subscription) bool { return sendLatest(sub.State, copied) }, "state")
+}
+
+func (this *subscriptions) sendConn(connected bool) {
+	this.mtx.Lock()
+	this.connected = &connected
+	this.mtx.Unlock()
+
+	this.each(func(sub *Subscription) bool { return sendLatest(sub.Conn, connected) }, "connection state")
+}
+
+func (this *subscriptions) sendFlow(flow FlowState) {
+	this.each(func(sub *Subscription) bool { return sendLatest(sub.Flow, flow) }, "energy flows")
+}
+
+func copyState(state PollState) PollState {
+	result := make(PollState)
+
+	for exportId, v := range state {
+		entry := *v
+		result[exportId] = &entry
+	}
+
+	return result
+}
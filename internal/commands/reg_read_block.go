@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"openess/internal/log"
+	"openess/internal/protocol"
+)
+
+type RegReadBlockCommand struct {
+	Block *protocol.RegisterBlock
+}
+
+type RegReadBlockResult struct {
+	Data   []byte
+	Values map[uint16]RegValue
+}
+
+func NewRegReadBlock(block *protocol.RegisterBlock) RegReadBlockCommand {
+	return RegReadBlockCommand{Block: block}
+}
+
+func (RegReadBlockCommand) CastResult(resp Result) RegReadBlockResult {
+	return resp.(RegReadBlockResult)
+}
+
+func (r RegReadBlockCommand) Handle(dev protocol.Device, descr *protocol.Descriptor) (Result, error) {
+	var result RegReadBlockResult
+
+	if descr == nil {
+		return nil, errors.New("descriptor is not loaded")
+	}
+
+	if r.Block == nil {
+		return nil, errors.New("invalid arguments: block is null")
+	}
+
+	devAddr := byte(descr.Configuration.DevAddrs[0])
+
+	wireAddr, err := descr.WireAddress(r.Block.StartAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	raw_req := NewRegReadRaw(devAddr, r.Block.FunNumber, wireAddr, r.Block.Length)
+
+	res, err := raw_req.Handle(dev, descr)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Data = raw_req.CastResult(res).Data
+	result.Values = DecodeRegisters(result.Data, r.Block.FunNumber, r.Block.StartAddress, descr)
+
+	return result, nil
+}
+
+// Decodes all descriptor registers found in continuous register data read with the function
+// code starting at addr. Words not belonging to any register are skipped.
+func DecodeRegisters(data []byte, funNumber byte, addr uint16, descr *protocol.Descriptor) map[uint16]RegValue {
+	values := make(map[uint16]RegValue)
+	buf := bytes.NewBuffer(data)
+
+	for buf.Len() > 0 {
+		reg := descr.FindRegisterByAddrFun(funNumber, addr)
+		if reg == nil {
+			log.PrDebug("commands:read_block: no register in descriptor at %d, skipping\n", addr)
+			buf.Next(2)
+			addr += 1
+			continue
+		}
+
+		length := uint16(1)
+		if reg.Length != nil {
+			length = uint16(*reg.Length)
+		}
+
+		if buf.Len() < int(length)*2 {
+			log.PrError("commands:read_block: register %d is truncated\n", addr)
+			break
+		}
+
+		values[addr] = NewRegValueFromBytes(buf, reg, descr)
+		addr += length
+	}
+
+	return values
+}
@@ -3,6 +3,7 @@ package commands
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,30 +11,158 @@ import (
 	"openess/internal/log"
 	"openess/internal/protocol"
 	"strconv"
+	"strings"
 )
 
 type RegType int
 
 const (
-	RegTypeInt   RegType = 0
-	RegTypeEnum  RegType = 1
-	RegTypeFloat RegType = 2
+	RegTypeInt    RegType = 0
+	RegTypeEnum   RegType = 1
+	RegTypeFloat  RegType = 2
+	RegTypeString RegType = 3
+	RegTypeFlags  RegType = 4
 )
 
 type RegValue struct {
-	Type       RegType
-	ValueRaw   uint32
-	ValueInt   *int
-	ValueEnum  *string
-	ValueFloat *float32
-	Units      *string
+	Type        RegType
+	ValueRaw    uint32
+	ValueInt    *int
+	ValueEnum   *string
+	ValueFloat  *float32
+	ValueString *string
+	ValueFlags  []string
+	ValueLabel  *string
+	Units       *string
+	Digits      *int
 }
 
 func nativeIsBigEndian() bool {
 	return binary.NativeEndian.Uint16([]byte{0x0a, 0x0b}) == uint16(0x0a0b)
 }
 
+func newStringRegValue(buf io.Reader, reg *protocol.Register) RegValue {
+	var value RegValue
+	value.Type = RegTypeString
+	value.Units = &reg.Units
+
+	length := 1
+	if reg.Length != nil {
+		length = *reg.Length
+	}
+
+	data := make([]byte, length*2)
+	_, err := io.ReadFull(buf, data)
+	if err != nil {
+		log.PrError("reg_read_descr: failed to read string register %d: %s\n", reg.Address, err)
+	}
+
+	// each word carries two characters, high byte first unless the register is little endian
+	if reg.ByteSort == protocol.ByteSortLittleEndian {
+		for i := 0; i+1 < len(data); i += 2 {
+			data[i], data[i+1] = data[i+1], data[i]
+		}
+	}
+
+	str := strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", ""))
+	value.ValueString = &str
+
+	return value
+}
+
+// Renders BCD, version and packed date/time registers the way SmartESS shows them.
+// A single word register is split into bytes (high byte first), a longer one into words
+// (in register order). Parts are decoded according to bcdType (plain numbers without it)
+// and joined with spaceMark, so 0x0C1F is "12.31" with "." separator.
+func formatPackedValue(words []uint16, reg *protocol.Register) string {
+	if reg.BcdType != nil && *reg.BcdType == protocol.BcdTypeNumber && reg.SpaceMark == "" && len(words) == 1 {
+		return strconv.FormatUint(uint64(bcdToInt(uint32(words[0]))), 10)
+	}
+
+	parts := []uint32{}
+	if len(words) == 1 {
+		parts = append(parts, uint32(words[0]>>8), uint32(words[0]&0xff))
+	} else {
+		for _, w := range words {
+			parts = append(parts, uint32(w))
+		}
+	}
+
+	strs := []string{}
+	for _, p := range parts {
+		switch {
+		case reg.BcdType == nil:
+			strs = append(strs, strconv.FormatUint(uint64(p), 10))
+		case *reg.BcdType == protocol.BcdTypeBinary:
+			strs = append(strs, fmt.Sprintf("%02d", p))
+		default:
+			strs = append(strs, fmt.Sprintf("%02d", bcdToInt(p)))
+		}
+	}
+
+	return strings.Join(strs, reg.SpaceMark)
+}
+
+// Registers rendered by formatPackedValue: BCD ones and plain ones with a separator
+// (e.g. "month/day" registers)
+func isPackedRegister(reg *protocol.Register) bool {
+	if reg.ValueType == protocol.ValueTypeBCD {
+		return true
+	}
+
+	return reg.SpaceMark != "" && reg.EnumerationStrings == nil && reg.SubModels == nil
+}
+
+func bcdToInt(bcd uint32) uint32 {
+	var result uint32
+	var mul uint32 = 1
+	for bcd != 0 {
+		result += (bcd & 0xf) * mul
+		mul *= 10
+		bcd >>= 4
+	}
+	return result
+}
+
+// Expands a bitfield register (subModels) into a list of its active fields.
+// Single bit fields are listed by their title, wider ones also carry their value.
+func decodeFlags(raw uint32, fields []protocol.Register, lang string) []string {
+	flags := []string{}
+
+	for _, f := range fields {
+		width := 1
+		if f.Length != nil {
+			width = *f.Length
+		}
+
+		v := (raw >> f.Address) & (1<<width - 1)
+		if v == 0 {
+			continue
+		}
+
+		name := f.Name(lang)
+		if width == 1 {
+			flags = append(flags, name)
+			continue
+		}
+
+		label := strconv.Itoa(int(v))
+		if f.EnumerationStrings != nil {
+			if l, ok := f.EnumerationStrings.Label(protocol.EnumVariant(v), lang); ok && l != nil {
+				label = *l
+			}
+		}
+		flags = append(flags, fmt.Sprintf("%s: %s", name, label))
+	}
+
+	return flags
+}
+
 func NewRegValueFromBytes(buf io.Reader, reg *protocol.Register, desc *protocol.Descriptor) RegValue {
+	if reg.ValueType == protocol.ValueTypeString {
+		return newStringRegValue(buf, reg)
+	}
+
 	var order binary.ByteOrder = binary.BigEndian
 	if reg.ByteSort == protocol.ByteSortLittleEndian {
 		order = binary.LittleEndian
@@ -41,27 +170,60 @@ func NewRegValueFromBytes(buf io.Reader, reg *protocol.Register, desc *protocol.
 
 	var value RegValue
 	value.Units = &reg.Units
+	value.Digits = reg.Digits
+
+	length := 1
+	if reg.Length != nil {
+		length = *reg.Length
+	}
+
+	if length < 1 {
+		length = 1
+	}
 
-	if reg.Length == nil || *reg.Length == 1 {
-		var val uint16
-		binary.Read(buf, order, &val)
-		value.ValueRaw = uint32(val)
-	} else if *reg.Length == 2 {
-		var val uint32
-		var word uint16
+	words := make([]uint16, length)
+	binary.Read(buf, order, words)
 
-		binary.Read(buf, order, &word)
-		val = uint32(word)
-		binary.Read(buf, order, &word)
-		val |= (uint32(word) << 16)
+	if length == 1 {
+		value.ValueRaw = uint32(words[0])
+	} else if length == 2 {
+		val := uint32(words[0]) | (uint32(words[1]) << 16)
 
 		if nativeIsBigEndian() {
 			val = ((val&0xff)<<16 | (val >> 16))
 		}
 
 		value.ValueRaw = val
-	} else {
-		log.PrError("reg_read_descr: unexpected register length: %d\n", *reg.Length)
+	} else if !isPackedRegister(reg) {
+		log.PrError("reg_read_descr: unexpected register length: %d\n", length)
+	}
+
+	if isPackedRegister(reg) {
+		value.Type = RegTypeString
+		str := formatPackedValue(words, reg)
+		value.ValueString = &str
+		return value
+	}
+
+	// valueType 0 registers hold two's complement values
+	num := int64(value.ValueRaw)
+	if reg.ValueType == protocol.ValueTypeSigned {
+		if reg.Length == nil || *reg.Length == 1 {
+			num = int64(int16(value.ValueRaw))
+		} else {
+			num = int64(int32(value.ValueRaw))
+		}
+	}
+
+	if reg.SubModels != nil {
+		bitfield, ok := desc.OtherCodes[*reg.SubModels]
+		if ok && len(bitfield.Fields) != 0 {
+			value.Type = RegTypeFlags
+			value.ValueFlags = decodeFlags(value.ValueRaw, bitfield.Fields, desc.Language)
+			return value
+		}
+
+		log.PrError("reg_read_descr: failed to find bitfield: reg = %d bitfield = %s\n", reg.Address, *reg.SubModels)
 	}
 
 	if reg.EnumerationStrings != nil {
@@ -77,7 +239,7 @@ func NewRegValueFromBytes(buf io.Reader, reg *protocol.Register, desc *protocol.
 				return value
 			}
 
-			enumStr, ok := enum.Variants[int(value.ValueRaw)]
+			enumStr, ok := enum.Label(int(value.ValueRaw), desc.Language)
 
 			if !ok {
 				log.PrError("reg_read_descr: failed to find external enum value: reg = %d enum = %s value = %d\n", reg.Address, *reg.EnumerationStrings.External, int(value.ValueRaw))
@@ -90,7 +252,7 @@ func NewRegValueFromBytes(buf io.Reader, reg *protocol.Register, desc *protocol.
 			return value
 		}
 
-		enumStr, ok := reg.EnumerationStrings.Variants[protocol.EnumVariant(value.ValueRaw)]
+		enumStr, ok := reg.EnumerationStrings.Label(protocol.EnumVariant(value.ValueRaw), desc.Language)
 
 		if !ok {
 			log.PrError("reg_read_descr: failed to find symbolic enum value: reg = %d value = %d\n", reg.Address, value.ValueRaw)
@@ -100,19 +262,46 @@ func NewRegValueFromBytes(buf io.Reader, reg *protocol.Register, desc *protocol.
 		}
 
 		value.ValueEnum = enumStr
-	} else if math.Abs(float64(reg.Scale)-1.0) < 0.0001 {
+	} else if math.Abs(float64(reg.Scale)-1.0) < 0.0001 && reg.Offset == float32(math.Trunc(float64(reg.Offset))) {
 		value.Type = RegTypeInt
-		v := int(value.ValueRaw)
+		v := int(num) + int(reg.Offset)
 		value.ValueInt = &v
 	} else {
 		value.Type = RegTypeFloat
-		v := float32(value.ValueRaw) * reg.Scale
+		v := float32(num)*reg.Scale + reg.Offset
 		value.ValueFloat = &v
 	}
 
+	if reg.RangeEnumerationStrings != nil {
+		scaled := float64(num) + float64(reg.Offset)
+		if value.Type == RegTypeFloat {
+			// float32 scaling is not exact, round so that range bounds still match
+			scaled = math.Round(float64(*value.ValueFloat)*1e6) / 1e6
+		}
+		value.ValueLabel = reg.RangeEnumerationStrings.Find(scaled)
+	}
+
 	return value
 }
 
+// Returns numeric value of int and float registers
+func (v RegValue) Number() (float64, bool) {
+	switch {
+	case v.Type == RegTypeInt && v.ValueInt != nil:
+		return float64(*v.ValueInt), true
+	case v.Type == RegTypeFloat && v.ValueFloat != nil:
+		return float64(*v.ValueFloat), true
+	}
+	return 0, false
+}
+
+func (v RegValue) digits() int {
+	if v.Digits == nil {
+		return 3
+	}
+	return *v.Digits
+}
+
 func (v RegValue) ToStringRaw() string {
 	switch v.Type {
 	case RegTypeInt:
@@ -120,7 +309,12 @@ func (v RegValue) ToStringRaw() string {
 	case RegTypeEnum:
 		return fmt.Sprintf("%s", *v.ValueEnum)
 	case RegTypeFloat:
-		return fmt.Sprintf("%.3f", *v.ValueFloat)
+		return fmt.Sprintf("%.*f", v.digits(), *v.ValueFloat)
+	case RegTypeString:
+		return *v.ValueString
+	case RegTypeFlags:
+		data, _ := json.Marshal(v.ValueFlags)
+		return string(data)
 	}
 
 	return ""
@@ -131,13 +325,24 @@ func (v RegValue) ToString() string {
 	if v.Units != nil {
 		units = *v.Units
 	}
+	label := ""
+	if v.ValueLabel != nil {
+		label = fmt.Sprintf(" (%s)", *v.ValueLabel)
+	}
 	switch v.Type {
 	case RegTypeInt:
-		return fmt.Sprintf("%d%s", *v.ValueInt, units)
+		return fmt.Sprintf("%d%s%s", *v.ValueInt, units, label)
 	case RegTypeEnum:
 		return fmt.Sprintf("%s", *v.ValueEnum)
 	case RegTypeFloat:
-		return fmt.Sprintf("%.3f%s", *v.ValueFloat, units)
+		return fmt.Sprintf("%.*f%s%s", v.digits(), *v.ValueFloat, units, label)
+	case RegTypeString:
+		return *v.ValueString
+	case RegTypeFlags:
+		if len(v.ValueFlags) == 0 {
+			return "none"
+		}
+		return strings.Join(v.ValueFlags, ", ")
 	}
 
 	return ""
@@ -160,6 +365,16 @@ func (RegReadDescrCommand) CastResult(resp Result) RegReadDescrResult {
 	return resp.(RegReadDescrResult)
 }
 
+// Returns an error if values of the register type can't be decoded
+func CheckValueType(reg *protocol.Register) error {
+	switch reg.ValueType {
+	case protocol.ValueTypeSigned, protocol.ValueTypeUnsigned, protocol.ValueTypeString, protocol.ValueTypeBCD:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("unsupported value type %d", reg.ValueType))
+	}
+}
+
 func (r RegReadDescrCommand) Handle(dev protocol.Device, descr *protocol.Descriptor) (Result, error) {
 	var result RegReadDescrResult
 
@@ -167,17 +382,25 @@ func (r RegReadDescrCommand) Handle(dev protocol.Device, descr *protocol.Descrip
 		return nil, errors.New("descriptor is not loaded")
 	}
 
-	if r.Register == nil || r.Segment == nil {
+	if r.Register == nil || (r.Segment == nil && r.Register.FunNumber == nil) {
 		return nil, errors.New("invalid arguments: reg or segment is null")
 	}
 
-	if r.Register.ValueType != 1 {
-		return nil, errors.New(fmt.Sprintf("unsupported value type %d", r.Register.ValueType))
+	if err := CheckValueType(r.Register); err != nil {
+		return nil, err
 	}
 
 	devAddr := byte(descr.Configuration.DevAddrs[0])
-	funcNumber := r.Segment.FunNumber
-	addr := r.Register.Address
+	var funcNumber byte
+	if r.Register.FunNumber != nil {
+		funcNumber = *r.Register.FunNumber
+	} else {
+		funcNumber = r.Segment.FunNumber
+	}
+	addr, err := descr.WireAddress(r.Register.Address)
+	if err != nil {
+		return nil, err
+	}
 	length := 1
 	if r.Register.Length != nil {
 		length = *r.Register.Length
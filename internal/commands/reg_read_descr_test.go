@@ -2,6 +2,7 @@ package commands
 
 import (
 	"bytes"
+	"encoding/json"
 	"openess/internal/protocol"
 	"testing"
 )
@@ -36,3 +37,294 @@ func TestEndianess(t* testing.T) {
         t.Fatalf("0x%x != 0x%x", expected, val.ValueRaw)
     }
 }
+
+func TestSignedValues(t* testing.T) {
+    length := 2
+    reg := protocol.Register {
+        ByteSort: protocol.ByteSortBigEndian,
+        ValueType: protocol.ValueTypeSigned,
+        Scale: 1.0,
+    }
+
+    descr := protocol.Descriptor {
+        Root: []protocol.Register { reg },
+        Configuration: protocol.Configuration{},
+    }
+
+    buf := bytes.NewBuffer([]byte { 0xff, 0x38 })
+    val := NewRegValueFromBytes(buf, &descr.Root[0], &descr)
+    if *val.ValueInt != -200 {
+        t.Fatalf("%d != %d", -200, *val.ValueInt)
+    }
+
+    buf = bytes.NewBuffer([]byte { 0xfc, 0x18, 0xff, 0xff })
+    descr.Root[0].Length = &length
+    descr.Root[0].Scale = 0.1
+    val = NewRegValueFromBytes(buf, &descr.Root[0], &descr)
+    if *val.ValueFloat > -99.9 || *val.ValueFloat < -100.1 {
+        t.Fatalf("%f != %f", -100.0, *val.ValueFloat)
+    }
+
+    buf = bytes.NewBuffer([]byte { 0xff, 0x38 })
+    descr.Root[0].Length = nil
+    descr.Root[0].Scale = 1.0
+    descr.Root[0].ValueType = protocol.ValueTypeUnsigned
+    val = NewRegValueFromBytes(buf, &descr.Root[0], &descr)
+    if *val.ValueInt != 65336 {
+        t.Fatalf("%d != %d", 65336, *val.ValueInt)
+    }
+}
+
+func TestStringValues(t* testing.T) {
+    length := 4
+    reg := protocol.Register {
+        ByteSort: protocol.ByteSortBigEndian,
+        ValueType: protocol.ValueTypeString,
+        Length: &length,
+    }
+
+    descr := protocol.Descriptor {
+        Root: []protocol.Register { reg },
+        Configuration: protocol.Configuration{},
+    }
+
+    buf := bytes.NewBuffer([]byte("VM5500\x00\x00"))
+    val := NewRegValueFromBytes(buf, &descr.Root[0], &descr)
+    if val.ToStringRaw() != "VM5500" {
+        t.Fatalf("%q != %q", "VM5500", val.ToStringRaw())
+    }
+
+    buf = bytes.NewBuffer([]byte("MV5500\x00\x00\x00"))
+    descr.Root[0].ByteSort = protocol.ByteSortLittleEndian
+    val = NewRegValueFromBytes(buf, &descr.Root[0], &descr)
+    if val.ToStringRaw() != "VM5500" {
+        t.Fatalf("%q != %q", "VM5500", val.ToStringRaw())
+    }
+    if buf.Len() != 1 {
+        t.Fatalf("expected %d bytes left, got %d", 1, buf.Len())
+    }
+}
+
+func TestBcdValues(t* testing.T) {
+    bcdPacked := protocol.BcdTypePacked
+    bcdBinary := protocol.BcdTypeBinary
+    bcdNumber := protocol.BcdTypeNumber
+
+    tests := []struct {
+        input     []byte
+        bcdType   *int
+        spaceMark string
+        expected  string
+    } {
+        { []byte { 0x0c, 0x1f }, nil, ".", "12.31" },
+        { []byte { 0x12, 0x30 }, &bcdPacked, ":", "12:30" },
+        { []byte { 0x20, 0x24 }, &bcdPacked, "", "2024" },
+        { []byte { 0x08, 0x1e }, &bcdBinary, ":", "08:30" },
+        { []byte { 0x00, 0x45 }, &bcdNumber, "", "45" },
+    }
+
+    for _, test := range tests {
+        reg := protocol.Register {
+            ByteSort: protocol.ByteSortBigEndian,
+            ValueType: protocol.ValueTypeBCD,
+            BcdType: test.bcdType,
+            SpaceMark: test.spaceMark,
+        }
+
+        descr := protocol.Descriptor {
+            Root: []protocol.Register { reg },
+            Configuration: protocol.Configuration{},
+        }
+
+        val := NewRegValueFromBytes(bytes.NewBuffer(test.input), &descr.Root[0], &descr)
+        if val.ToString() != test.expected {
+            t.Fatalf("%q != %q", test.expected, val.ToString())
+        }
+    }
+}
+
+func TestPackedDescriptorValues(t* testing.T) {
+    tests := []struct {
+        descriptor string
+        address    uint16
+        input      []byte
+        expected   string
+    } {
+        { "0200", 2, []byte { 0x01, 0x02 }, "1.2" },
+        { "0200", 22, []byte { 0x18, 0x0c }, "24/12" },
+        { "0911", 30219, []byte { 0x0c, 0x1f }, "12/31" },
+        { "0911", 40201, []byte { 0x00, 0x45 }, "45" },
+        { "0911", 40202, []byte { 0x23, 0x59 }, "23:59" },
+        { "0911", 40203, []byte { 0x12, 0x31 }, "12/31" },
+        { "0911", 40204, []byte { 0x20, 0x24 }, "2024" },
+        { "0911", 40615, []byte { 0x08, 0x1e }, "08:30" },
+        { "0911", 42203, []byte { 0x0c, 0x1f }, "12:31" },
+        { "0975", 22, []byte { 0x00, 0x08, 0x00, 0x1e }, "8:30" },
+    }
+
+    for _, test := range tests {
+        descr, err := protocol.LoadProtocolDescriptor("../../data/" + test.descriptor + ".json")
+        if err != nil {
+            t.Fatalf("failed to load %s: %s", test.descriptor, err)
+        }
+
+        reg := descr.FindRegisterByAddr(test.address)
+        if reg == nil {
+            t.Fatalf("%s: register %d not found", test.descriptor, test.address)
+        }
+
+        val := NewRegValueFromBytes(bytes.NewBuffer(test.input), reg, descr)
+        if val.ToString() != test.expected {
+            t.Fatalf("%s %q: %q != %q", test.descriptor, reg.Name("base"), test.expected, val.ToString())
+        }
+    }
+
+    length := 3
+    reg := protocol.Register {
+        ValueType: protocol.ValueTypeBCD,
+        Length: &length,
+        SpaceMark: ".",
+    }
+
+    descr := protocol.Descriptor { Root: []protocol.Register { reg } }
+
+    val := NewRegValueFromBytes(bytes.NewBuffer([]byte { 0x00, 0x01, 0x00, 0x02, 0x00, 0x0a }), &descr.Root[0], &descr)
+    if val.ToString() != "1.2.10" {
+        t.Fatalf("%q != %q", "1.2.10", val.ToString())
+    }
+}
+
+func TestBitfieldValues(t* testing.T) {
+    bitfield := "ErrorCode1"
+    width := 2
+    fault := "fault"
+    charging := "charging"
+
+    reg := protocol.Register {
+        ByteSort: protocol.ByteSortBigEndian,
+        ValueType: protocol.ValueTypeUnsigned,
+        SubModels: &bitfield,
+    }
+
+    descr := protocol.Descriptor {
+        Root: []protocol.Register { reg },
+        OtherCodes: map[string]protocol.ExternEnum {
+            "ErrorCode1": {
+                Fields: []protocol.Register {
+                    { Address: 0, Title: map[string]string { "base": "Fan locked" } },
+                    { Address: 1, Title: map[string]string { "base": "Over temperature" } },
+                    { Address: 2, Title: map[string]string { "base": "Battery voltage high" } },
+                    { Address: 4, Length: &width, Title: map[string]string { "base": "Battery" },
+                      EnumerationStrings: &protocol.Enumeration {
+                        Variants: map[protocol.EnumVariant]*string { 1: &fault, 2: &charging },
+                      },
+                    },
+                },
+            },
+        },
+    }
+
+    val := NewRegValueFromBytes(bytes.NewBuffer([]byte { 0x00, 0x25 }), &descr.Root[0], &descr)
+    expected := `["Fan locked","Battery voltage high","Battery: charging"]`
+    if val.ToStringRaw() != expected {
+        t.Fatalf("%s != %s", expected, val.ToStringRaw())
+    }
+
+    val = NewRegValueFromBytes(bytes.NewBuffer([]byte { 0x00, 0x00 }), &descr.Root[0], &descr)
+    if val.ToStringRaw() != "[]" || val.ToString() != "none" {
+        t.Fatalf("unexpected empty bitfield: %s / %s", val.ToStringRaw(), val.ToString())
+    }
+}
+
+func TestRangeLabels(t* testing.T) {
+    reg := protocol.Register {
+        ByteSort: protocol.ByteSortBigEndian,
+        ValueType: protocol.ValueTypeSigned,
+        Scale: 0.01,
+        RangeEnumerationStrings: &protocol.RangeEnumeration {
+            Variants: []protocol.RangeVariant {
+                { Min: 0, Max: 1, EqMax: true, Value: "lagging" },
+                { Min: 1, Max: 2, Value: "leading" },
+                { Min: -1, Max: 0, EqMin: true, Value: "lagging" },
+                { Min: -2, Max: -1, Value: "leading" },
+            },
+        },
+    }
+
+    descr := protocol.Descriptor {
+        Root: []protocol.Register { reg },
+    }
+
+    tests := map[uint16]string {
+        100: "lagging",
+        150: "leading",
+        0xffa6: "lagging",
+        0xff6a: "leading",
+    }
+
+    for raw, expected := range tests {
+        buf := bytes.NewBuffer([]byte { byte(raw >> 8), byte(raw) })
+        val := NewRegValueFromBytes(buf, &descr.Root[0], &descr)
+        if val.ValueLabel == nil || *val.ValueLabel != expected {
+            t.Fatalf("%d: expected %s, got %s", raw, expected, val.ToString())
+        }
+    }
+}
+
+func TestOffsetAndDigits(t* testing.T) {
+    digits := 1
+    reg := protocol.Register {
+        ByteSort: protocol.ByteSortBigEndian,
+        ValueType: protocol.ValueTypeUnsigned,
+        Scale: 1.0,
+        Offset: 2000,
+    }
+
+    descr := protocol.Descriptor {
+        Root: []protocol.Register { reg },
+    }
+
+    val := NewRegValueFromBytes(bytes.NewBuffer([]byte { 0x00, 0x18 }), &descr.Root[0], &descr)
+    if val.ToStringRaw() != "2024" {
+        t.Fatalf("%s != %s", "2024", val.ToStringRaw())
+    }
+
+    descr.Root[0].Scale = 0.1
+    descr.Root[0].Offset = -40
+    descr.Root[0].Digits = &digits
+    val = NewRegValueFromBytes(bytes.NewBuffer([]byte { 0x02, 0x9b }), &descr.Root[0], &descr)
+    if val.ToStringRaw() != "26.7" {
+        t.Fatalf("%s != %s", "26.7", val.ToStringRaw())
+    }
+}
+
+func TestLocalizedEnum(t* testing.T) {
+    var reg protocol.Register
+    err := json.Unmarshal([]byte(`{
+        "address": 1, "valueType": 1, "scale": 1,
+        "title": {"base": "Working State", "zh_cn": "工作状态"},
+        "enumerationStrings": {"base": {"0": "Power On", "1": "Test"}, "zh_cn": {"0": "上电模式"}}
+    }`), &reg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    descr := protocol.Descriptor {
+        Root: []protocol.Register { reg },
+        Language: "zh_cn",
+    }
+
+    val := NewRegValueFromBytes(bytes.NewBuffer([]byte { 0x00, 0x00 }), &descr.Root[0], &descr)
+    if val.ToString() != "上电模式" {
+        t.Fatalf("%s != %s", "上电模式", val.ToString())
+    }
+
+    val = NewRegValueFromBytes(bytes.NewBuffer([]byte { 0x00, 0x01 }), &descr.Root[0], &descr)
+    if val.ToString() != "Test" {
+        t.Fatalf("%s != %s", "Test", val.ToString())
+    }
+
+    if _, r := descr.FindRegister("工作状态"); r != &descr.Root[0] {
+        t.Fatalf("failed to find register by translated title")
+    }
+}
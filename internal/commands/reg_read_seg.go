@@ -41,14 +41,18 @@ func (r RegReadSegCommand) Handle(dev protocol.Device, descr *protocol.Descripto
 
 func (r RegReadSegCommand) HandleContinuous(dev protocol.Device, descr *protocol.Descriptor) (Result, error) {
 	var result RegReadSegResult
-	result.Values = make(map[uint16]RegValue)
 
 	devAddr := byte(descr.Configuration.DevAddrs[0])
 	funcNumber := r.Segment.FunNumber
 	addr := r.Segment.StartAddress
 	length := r.Segment.Length
 
-	raw_req := NewRegReadRaw(devAddr, funcNumber, addr, length)
+	wireAddr, err := descr.WireAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	raw_req := NewRegReadRaw(devAddr, funcNumber, wireAddr, length)
 
 	res, err := raw_req.Handle(dev, descr)
 	if err != nil {
@@ -56,30 +60,35 @@ func (r RegReadSegCommand) HandleContinuous(dev protocol.Device, descr *protocol
 	}
 
 	raw_result := raw_req.CastResult(res)
-	buf := bytes.NewBuffer(raw_result.Data)
+	result.Values = DecodeRegisters(raw_result.Data, funcNumber, addr, descr)
+
+	return result, nil
+}
 
-	for {
-		reg := descr.FindRegisterByAddr(addr)
+// Returns registers of the segment read with its function code, registers of other
+// function codes at the same addresses (e.g. input registers in 0975) are skipped
+func segmentRegisters(descr *protocol.Descriptor, seg *protocol.Segment) []*protocol.Register {
+	regs := []*protocol.Register{}
+	addr := seg.StartAddress
+
+	for addr < seg.StartAddress+seg.Length {
+		reg := descr.FindRegisterByAddrFun(seg.FunNumber, addr)
 		if reg == nil {
-			log.PrError("commands:read_segment: failed to find register (invalid descrptor): %d", addr)
+			log.PrError("commands:read_segment: failed to find register (invalid descrptor): %d\n", addr)
+			addr += 1
 			continue
 		}
 
-		val := NewRegValueFromBytes(buf, reg, descr)
-		result.Values[addr] = val
+		regs = append(regs, reg)
 
-		if reg.Length == nil {
-			addr += 1
-		} else {
+		if reg.Length != nil {
 			addr += uint16(*reg.Length)
-		}
-
-		if buf.Len() == 0 {
-			break
+		} else {
+			addr += 1
 		}
 	}
 
-	return result, nil
+	return regs
 }
 
 func (r RegReadSegCommand) HandleSparse(dev protocol.Device, descr *protocol.Descriptor) (Result, error) {
@@ -88,24 +97,25 @@ func (r RegReadSegCommand) HandleSparse(dev protocol.Device, descr *protocol.Des
 
 	devAddr := byte(descr.Configuration.DevAddrs[0])
 	funcNumber := r.Segment.FunNumber
-	addr := r.Segment.StartAddress
 
-	for {
-		reg := descr.FindRegisterByAddr(addr)
-		if reg == nil {
-			log.PrError("commands:read_segment: failed to find register (invalid descrptor): %d", addr)
-			continue
-		}
+	for _, reg := range segmentRegisters(descr, r.Segment) {
+		addr := reg.Address
 
 		length := 1
 		if reg.Length != nil {
 			length = *reg.Length
 		}
-		raw_req := NewRegReadRaw(devAddr, funcNumber, addr, uint16(length))
+		wireAddr, err := descr.WireAddress(addr)
+		if err != nil {
+			log.PrError("commands:read_segment: failed to read register %d: %s\n", addr, err)
+			continue
+		}
+
+		raw_req := NewRegReadRaw(devAddr, funcNumber, wireAddr, uint16(length))
 
 		res, err := raw_req.Handle(dev, descr)
 		if err != nil {
-			log.PrError("commands:read_segment: failed to read register %d: %s", addr, err)
+			log.PrError("commands:read_segment: failed to read register %d: %s\n", addr, err)
 			continue
 		}
 
@@ -113,12 +123,6 @@ func (r RegReadSegCommand) HandleSparse(dev protocol.Device, descr *protocol.Des
 		buf := bytes.NewBuffer(raw_result.Data)
 		val := NewRegValueFromBytes(buf, reg, descr)
 		result.Values[addr] = val
-
-		addr += uint16(length)
-
-		if addr >= r.Segment.StartAddress+r.Segment.Length {
-			break
-		}
 	}
 
 	return result, nil
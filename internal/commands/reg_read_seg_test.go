@@ -0,0 +1,32 @@
+package commands
+
+import (
+	"openess/internal/log"
+	"openess/internal/protocol"
+	"testing"
+)
+
+func TestSegmentRegisters(t *testing.T) {
+	log.Init(log.LOG_OFF)
+
+	holding, input := byte(3), byte(4)
+	two := 2
+
+	desc := protocol.Descriptor{
+		Root: []protocol.Register{
+			{Address: 10, Length: &two, Title: map[string]string{"base": "Device model"}, FunNumber: &input},
+			{Address: 10, Title: map[string]string{"base": "Grid charging"}, FunNumber: &holding},
+			{Address: 11, Title: map[string]string{"base": "Mode"}},
+		},
+	}
+
+	regs := segmentRegisters(&desc, &protocol.Segment{StartAddress: 10, Length: 2, FunNumber: 3, CanEdit: true})
+	if len(regs) != 2 || regs[0] != &desc.Root[1] || regs[1] != &desc.Root[2] {
+		t.Fatalf("unexpected holding registers %v", regs)
+	}
+
+	regs = segmentRegisters(&desc, &protocol.Segment{StartAddress: 10, Length: 2, FunNumber: 4})
+	if len(regs) != 1 || regs[0] != &desc.Root[0] {
+		t.Fatalf("unexpected input registers %v", regs)
+	}
+}
@@ -5,9 +5,15 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 	"openess/internal/protocol"
+	"strconv"
+	"strings"
 )
 
+// Function code of holding registers, the only ones writable with WriteOneFunCode
+const readHoldingFunCode = 3
+
 type RegWriteDescrCommand struct {
 	Segment  *protocol.Segment
 	Register *protocol.Register
@@ -33,11 +39,11 @@ func (r RegWriteDescrCommand) Handle(dev protocol.Device, descr *protocol.Descri
 		return nil, errors.New("descriptor is not loaded")
 	}
 
-	if r.Register == nil || r.Segment == nil {
+	if r.Register == nil || (r.Segment == nil && r.Register.FunNumber == nil) {
 		return nil, errors.New("invalid arguments: reg or segment is null")
 	}
 
-	if r.Register.ValueType != 1 {
+	if r.Register.ValueType != protocol.ValueTypeUnsigned && r.Register.ValueType != protocol.ValueTypeSigned {
 		return nil, errors.New(fmt.Sprintf("unsupported value type %d", r.Register.ValueType))
 	}
 
@@ -46,9 +52,24 @@ func (r RegWriteDescrCommand) Handle(dev protocol.Device, descr *protocol.Descri
 		order = binary.LittleEndian
 	}
 
+	// only registers read as holding registers are writable, e.g. not the ones of LoopCMDs input blocks
+	var readFunNumber byte
+	if r.Register.FunNumber != nil {
+		readFunNumber = *r.Register.FunNumber
+	} else {
+		readFunNumber = r.Segment.FunNumber
+	}
+
+	if readFunNumber != readHoldingFunCode {
+		return nil, errors.New(fmt.Sprintf("register %d is read with function %d and can't be written", r.Register.Address, readFunNumber))
+	}
+
 	devAddr := byte(descr.Configuration.DevAddrs[0])
 	funcNumber := descr.Configuration.WriteOneFunCode
-	addr := r.Register.Address
+	addr, err := descr.WireAddress(r.Register.Address)
+	if err != nil {
+		return nil, err
+	}
 	length := 1
 	if r.Register.Length != nil {
 		length = *r.Register.Length
@@ -62,10 +83,12 @@ func (r RegWriteDescrCommand) Handle(dev protocol.Device, descr *protocol.Descri
 
 	rawValue := 0
 
-	if (float64(r.Register.Scale)-1.0) < 0.0001 || r.Register.EnumerationStrings != nil {
+	if r.Register.EnumerationStrings != nil {
 		rawValue = int(r.Value)
+	} else if math.Abs(float64(r.Register.Scale)-1.0) < 0.0001 {
+		rawValue = int(math.Round(float64(r.Value - r.Register.Offset)))
 	} else {
-		rawValue = int(r.Value / r.Register.Scale)
+		rawValue = int(math.Round(float64((r.Value - r.Register.Offset) / r.Register.Scale)))
 	}
 
 	binary.Write(buf, order, uint16(rawValue))
@@ -81,3 +104,72 @@ func (r RegWriteDescrCommand) Handle(dev protocol.Device, descr *protocol.Descri
 
 	return result, nil
 }
+
+// Finds editable segment containing the register
+func FindEditableSegment(descr *protocol.Descriptor, reg *protocol.Register) (*protocol.Segment, error) {
+	for _, ref := range descr.LocateRegister(reg) {
+		if ref.Segment.CanEdit {
+			return ref.Segment, nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("register %d is not in any editable segment", reg.Address))
+}
+
+// Parses a value to be written to the register. Enumerations accept variant labels (base or
+// localized, case insensitive) and variant numbers, other registers accept numbers which
+// can be represented with the register scale and size.
+func ParseWriteValue(descr *protocol.Descriptor, reg *protocol.Register, input string) (float32, error) {
+	input = strings.TrimSpace(input)
+
+	if reg.EnumerationStrings != nil {
+		// base labels are the ones of a descriptor without language
+		base := protocol.Descriptor{OtherCodes: descr.OtherCodes}
+
+		labels := descr.EnumLabels(reg)
+		baseLabels := base.EnumLabels(reg)
+
+		if variant, err := strconv.Atoi(input); err == nil {
+			if _, ok := baseLabels[variant]; ok {
+				return float32(variant), nil
+			}
+			return 0, errors.New(fmt.Sprintf("unknown enumeration variant %d", variant))
+		}
+
+		for _, candidates := range []map[int]string{labels, baseLabels} {
+			for variant, label := range candidates {
+				if strings.EqualFold(label, input) {
+					return float32(variant), nil
+				}
+			}
+		}
+
+		return 0, errors.New(fmt.Sprintf("unknown enumeration variant %q", input))
+	}
+
+	value, err := strconv.ParseFloat(input, 32)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid number %q", input))
+	}
+
+	scale := float64(reg.Scale)
+	if math.Abs(scale-1.0) < 0.0001 {
+		scale = 1
+	}
+
+	raw := (value - float64(reg.Offset)) / scale
+	if math.Abs(raw-math.Round(raw)) > 0.001 {
+		return 0, errors.New(fmt.Sprintf("value %s is not a multiple of register scale %g", input, scale))
+	}
+
+	minRaw, maxRaw := 0.0, float64(math.MaxUint16)
+	if reg.ValueType == protocol.ValueTypeSigned {
+		minRaw, maxRaw = math.MinInt16, math.MaxInt16
+	}
+
+	if math.Round(raw) < minRaw || math.Round(raw) > maxRaw {
+		return 0, errors.New(fmt.Sprintf("value %s is out of register range", input))
+	}
+
+	return float32(value), nil
+}
@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"openess/internal/protocol"
+	"testing"
+)
+
+func TestParseWriteValue(t *testing.T) {
+	utility, solar := "Utility first", "Solar first"
+
+	desc := protocol.Descriptor{
+		Root: []protocol.Register{
+			{Address: 1, Scale: 0.1, ValueType: protocol.ValueTypeUnsigned},
+			{Address: 2, Scale: 1, ValueType: protocol.ValueTypeSigned, Offset: -40},
+			{Address: 3, Scale: 1, EnumerationStrings: &protocol.Enumeration{
+				Variants: map[protocol.EnumVariant]*string{0: &utility, 1: &solar},
+				Translations: map[string]map[protocol.EnumVariant]*string{
+					"de": {1: &[]string{"Solar zuerst"}[0]},
+				},
+			}},
+		},
+		Configuration: protocol.Configuration{
+			SystemSettingVC: []protocol.ConfigurationGroup{
+				{Segments: []protocol.Segment{{StartAddress: 1, Length: 1, CanEdit: true}}},
+			},
+			SystemInfoVC: []protocol.ConfigurationGroup{
+				{Segments: []protocol.Segment{{StartAddress: 2, Length: 2}}},
+			},
+		},
+		Language: "de",
+	}
+
+	valid := []struct {
+		reg      int
+		input    string
+		expected float32
+	}{
+		{0, "54.1", 54.1},
+		{0, "6553.5", 6553.5},
+		{1, "-10", -10},
+		{2, "1", 1},
+		{2, "utility FIRST", 0},
+		{2, "Solar zuerst", 1},
+	}
+
+	for _, v := range valid {
+		value, err := ParseWriteValue(&desc, &desc.Root[v.reg], v.input)
+		if err != nil || value != v.expected {
+			t.Fatalf("%s: %f != %f (%v)", v.input, v.expected, value, err)
+		}
+	}
+
+	invalid := []struct {
+		reg   int
+		input string
+	}{
+		{0, "54.15"},
+		{0, "-1"},
+		{0, "6553.6"},
+		{0, "abc"},
+		{1, "40000"},
+		{2, "2"},
+		{2, "Battery first"},
+	}
+
+	for _, v := range invalid {
+		if _, err := ParseWriteValue(&desc, &desc.Root[v.reg], v.input); err == nil {
+			t.Fatalf("%s: expected an error", v.input)
+		}
+	}
+
+	if seg, err := FindEditableSegment(&desc, &desc.Root[0]); err != nil || !seg.CanEdit {
+		t.Fatalf("expected editable segment, got %v (%v)", seg, err)
+	}
+
+	if _, err := FindEditableSegment(&desc, &desc.Root[1]); err == nil {
+		t.Fatalf("expected an error for read-only register")
+	}
+
+	input := byte(4)
+	reg := protocol.Register{Address: 5, Scale: 1, ValueType: protocol.ValueTypeUnsigned, FunNumber: &input}
+	desc.Configuration.DevAddrs = []protocol.DevAddr{1}
+
+	if _, err := NewRegWriteDescr(nil, &reg, 1).Handle(protocol.Device{}, &desc); err == nil {
+		t.Fatalf("expected an error for input register outside of segments")
+	}
+}
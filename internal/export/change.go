@@ -0,0 +1,139 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"openess/internal/commands"
+	"time"
+)
+
+// Deadband of a register: numeric values are published only if they differ from the
+// last published value by more than Absolute and by more than Percent of it (zero
+// deadbands are not checked). Other values are published on any change.
+type ChangeConfig struct {
+	Absolute float64
+	Percent  float64
+	// Republish unchanged value after this time, never if empty
+	MaxSilence string
+}
+
+// Publish on change config, Registers override the default deadband by export id
+type OnChangeConfig struct {
+	ChangeConfig
+	Registers map[string]ChangeConfig
+}
+
+type changeRule struct {
+	absolute   float64
+	percent    float64
+	maxSilence time.Duration
+}
+
+type publishedValue struct {
+	value *commands.RegValue
+	at    time.Time
+}
+
+type changeFilter struct {
+	defaultRule changeRule
+	rules       map[string]changeRule
+	published   map[string]publishedValue
+}
+
+func newChangeRule(config ChangeConfig, defaultSilence time.Duration) (changeRule, error) {
+	rule := changeRule{
+		absolute:   config.Absolute,
+		percent:    config.Percent,
+		maxSilence: defaultSilence,
+	}
+
+	if config.MaxSilence != "" {
+		silence, err := time.ParseDuration(config.MaxSilence)
+		if err != nil {
+			return rule, err
+		}
+		rule.maxSilence = silence
+	}
+
+	return rule, nil
+}
+
+func newChangeFilter(config OnChangeConfig) (*changeFilter, error) {
+	defaultRule, err := newChangeRule(config.ChangeConfig, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := changeFilter{
+		defaultRule: defaultRule,
+		rules:       make(map[string]changeRule),
+		published:   make(map[string]publishedValue),
+	}
+
+	for name, regConfig := range config.Registers {
+		rule, err := newChangeRule(regConfig, defaultRule.maxSilence)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("register %s: %s", name, err))
+		}
+		filter.rules[name] = rule
+	}
+
+	return &filter, nil
+}
+
+func (this changeRule) isChanged(last *commands.RegValue, value *commands.RegValue) bool {
+	if last == nil || value == nil {
+		return last != value
+	}
+
+	lastNum, lastOk := last.Number()
+	num, ok := value.Number()
+
+	if !lastOk || !ok {
+		return last.ToStringRaw() != value.ToStringRaw()
+	}
+
+	delta := math.Abs(num - lastNum)
+
+	if delta == 0 {
+		return false
+	}
+	if this.absolute > 0 && delta <= this.absolute {
+		return false
+	}
+	if this.percent > 0 && delta <= math.Abs(lastNum)*this.percent/100 {
+		return false
+	}
+
+	return true
+}
+
+// Checks if the value should be published and remembers it as published if so
+func (this *changeFilter) update(name string, value *commands.RegValue, now time.Time) bool {
+	rule, ok := this.rules[name]
+	if !ok {
+		rule = this.defaultRule
+	}
+
+	last, ok := this.published[name]
+
+	publish := !ok || rule.isChanged(last.value, value) ||
+		(rule.maxSilence > 0 && now.Sub(last.at) >= rule.maxSilence)
+
+	if publish {
+		var copied *commands.RegValue
+		if value != nil {
+			v := *value
+			copied = &v
+		}
+		this.published[name] = publishedValue{value: copied, at: now}
+	}
+
+	return publish
+}
+
+// Forgets published values, so everything is published again (e.g. after reconnect)
+func (this *changeFilter) reset() {
+	this.published = make(map[string]publishedValue)
+}
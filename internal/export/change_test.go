@@ -0,0 +1,65 @@
+package export
+
+import (
+	"openess/internal/commands"
+	"testing"
+	"time"
+)
+
+func intValue(v int) *commands.RegValue {
+	return &commands.RegValue{Type: commands.RegTypeInt, ValueInt: &v}
+}
+
+func enumValue(v string) *commands.RegValue {
+	return &commands.RegValue{Type: commands.RegTypeEnum, ValueEnum: &v}
+}
+
+func TestChangeFilter(t *testing.T) {
+	filter, err := newChangeFilter(OnChangeConfig{
+		ChangeConfig: ChangeConfig{Absolute: 5, MaxSilence: "1m"},
+		Registers: map[string]ChangeConfig{
+			"power": {Percent: 10},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create filter: %s", err)
+	}
+
+	now := time.Now()
+
+	steps := []struct {
+		name    string
+		value   *commands.RegValue
+		at      time.Duration
+		publish bool
+	}{
+		{"voltage", intValue(230), 0, true},
+		{"voltage", intValue(233), time.Second, false},
+		{"voltage", intValue(236), 2 * time.Second, true},
+		{"voltage", intValue(236), time.Minute, false},
+		{"voltage", intValue(236), 2*time.Minute + 2*time.Second, true},
+		{"voltage", nil, 3 * time.Minute, true},
+		{"power", intValue(1000), 0, true},
+		{"power", intValue(1090), time.Second, false},
+		{"power", intValue(1101), time.Second, true},
+		{"power", intValue(1101), 2 * time.Minute, true},
+		{"mode", enumValue("Line"), 0, true},
+		{"mode", enumValue("Line"), time.Second, false},
+		{"mode", enumValue("Battery"), time.Second, true},
+	}
+
+	for i, step := range steps {
+		if filter.update(step.name, step.value, now.Add(step.at)) != step.publish {
+			t.Fatalf("%d: %s expected publish=%v", i, step.name, step.publish)
+		}
+	}
+
+	filter.reset()
+	if !filter.update("mode", enumValue("Battery"), now) {
+		t.Fatalf("expected publish after reset")
+	}
+
+	if _, err := newChangeFilter(OnChangeConfig{Registers: map[string]ChangeConfig{"x": {MaxSilence: "x"}}}); err == nil {
+		t.Fatalf("expected an error for invalid duration")
+	}
+}
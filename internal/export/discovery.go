@@ -0,0 +1,184 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"openess/internal/collector"
+	"openess/internal/commands"
+	"openess/internal/log"
+	"openess/internal/protocol"
+	"sort"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Home Assistant MQTT discovery config
+type DiscoveryConfig struct {
+	Enabled bool
+	// Discovery topic prefix, "homeassistant" if empty
+	Prefix string
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	SwVersion    string   `json:"sw_version,omitempty"`
+	HwVersion    string   `json:"hw_version,omitempty"`
+}
+
+type discoverySensor struct {
+	Name              string          `json:"name"`
+	UniqueId          string          `json:"unique_id"`
+	ObjectId          string          `json:"object_id"`
+	StateTopic        string          `json:"state_topic"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	UnitOfMeasurement string          `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class,omitempty"`
+	Options           []string        `json:"options,omitempty"`
+	ValueTemplate     string          `json:"value_template,omitempty"`
+	Device            discoveryDevice `json:"device"`
+}
+
+// Units known to Home Assistant mapped to device class and canonical unit
+var discoveryUnits = map[string][2]string{
+	"v":   {"voltage", "V"},
+	"a":   {"current", "A"},
+	"ma":  {"current", "mA"},
+	"w":   {"power", "W"},
+	"kw":  {"power", "kW"},
+	"hz":  {"frequency", "Hz"},
+	"va":  {"apparent_power", "VA"},
+	"var": {"reactive_power", "var"},
+	"kwh": {"energy", "kWh"},
+	"°c":  {"temperature", "°C"},
+	"℃":   {"temperature", "°C"},
+}
+
+// Converts a string to a valid discovery id: letters, digits, dashes and underscores only
+func discoveryId(s string) string {
+	id := strings.Builder{}
+
+	for _, c := range s {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '-' {
+			id.WriteRune(c)
+		} else {
+			id.WriteByte('_')
+		}
+	}
+
+	return id.String()
+}
+
+func newDiscoveryDevice(info *commands.DeviceInfoResult) (string, discoveryDevice) {
+	nodeId := "openess"
+	device := discoveryDevice{Name: "OpenESS inverter"}
+
+	if info != nil && info.SerialNumber != "" {
+		nodeId = "openess_" + discoveryId(info.SerialNumber)
+		device.Manufacturer = info.Manufacturer
+		device.Model = info.DeviceType
+		device.SwVersion = info.FirmwareVersion
+		device.HwVersion = info.HardwareVersion
+		device.Name = fmt.Sprintf("OpenESS inverter %s", info.SerialNumber)
+	}
+
+	device.Identifiers = []string{nodeId}
+
+	return nodeId, device
+}
+
+// Builds discovery topic and config payload of a polled register
+func newDiscoverySensor(prefix string, topics topicNames, desc *protocol.Descriptor,
+	info *commands.DeviceInfoResult, exportId string, reg *protocol.Register) (string, discoverySensor) {
+	nodeId, device := newDiscoveryDevice(info)
+	objectId := discoveryId(exportId)
+
+	sensor := discoverySensor{
+		Name:              reg.Name(desc.Language),
+		UniqueId:          nodeId + "_" + objectId,
+		ObjectId:          nodeId + "_" + objectId,
+		StateTopic:        topics.register(exportId),
+		AvailabilityTopic: topics.status(),
+		Device:            device,
+	}
+
+	if labels := desc.EnumLabels(reg); labels != nil {
+		variants := []int{}
+		for variant := range labels {
+			variants = append(variants, variant)
+		}
+		sort.Ints(variants)
+
+		sensor.DeviceClass = "enum"
+		for _, variant := range variants {
+			sensor.Options = append(sensor.Options, labels[variant])
+		}
+
+		// values without a label are published as numbers, they are shown as unknown
+		options, _ := json.Marshal(sensor.Options)
+		sensor.ValueTemplate = fmt.Sprintf("{{ value if value in %s else None }}", options)
+	} else if reg.ValueType != protocol.ValueTypeString && reg.ValueType != protocol.ValueTypeBCD &&
+		reg.SubModels == nil {
+		sensor.StateClass = "measurement"
+		sensor.UnitOfMeasurement = reg.Units
+
+		if unit, ok := discoveryUnits[strings.ToLower(reg.Units)]; ok {
+			sensor.DeviceClass = unit[0]
+			sensor.UnitOfMeasurement = unit[1]
+
+			if unit[0] == "energy" {
+				sensor.StateClass = "total_increasing"
+			}
+		}
+
+		// percents are loads, efficiencies and so on, only battery charge has a device class
+		name := strings.ToLower(reg.Name("base"))
+		if reg.Units == "%" && (strings.Contains(name, "soc") || strings.Contains(name, "battery capacity")) {
+			sensor.DeviceClass = "battery"
+		}
+	}
+
+	topic := fmt.Sprintf("%s/sensor/%s/%s/config", prefix, nodeId, objectId)
+
+	return topic, sensor
+}
+
+// Publishes retained discovery configs of all polled registers, returns nil if state is empty
+func (task *mqttExporterTask) publishDiscovery(state collector.PollState) mqtt.Token {
+	desc := task.device.GetDescriptor()
+	info := task.device.GetDeviceInfo()
+
+	prefix := task.discovery.Prefix
+	if prefix == "" {
+		prefix = "homeassistant"
+	}
+
+	var tok mqtt.Token
+
+	for exportId, v := range state {
+		topic, sensor := newDiscoverySensor(prefix, task.topics, desc, info, exportId, v.Register)
+
+		data, err := json.Marshal(sensor)
+		if err != nil {
+			log.PrError("export:mqtt: failed to encode discovery config of %s: %s\n", exportId, err)
+			continue
+		}
+
+		log.PrDebug("export:mqtt: publishing discovery config: %s = %s\n", topic, data)
+
+		tok = (*task.client).Publish(topic, task.qos, true, data)
+		tok.Wait()
+
+		if tok.Error() != nil {
+			return tok
+		}
+	}
+
+	log.PrInfo("export:mqtt: published discovery configs of %d registers\n", len(state))
+
+	return tok
+}
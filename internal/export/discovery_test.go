@@ -0,0 +1,69 @@
+package export
+
+import (
+	"openess/internal/commands"
+	"openess/internal/protocol"
+	"testing"
+)
+
+func TestDiscoverySensor(t *testing.T) {
+	line, battery := "Line", "Battery"
+	external := "modes"
+
+	desc := protocol.Descriptor{
+		Root: []protocol.Register{
+			{Address: 1, Title: map[string]string{"base": "Output voltage"}, Units: "v", Scale: 0.1},
+			{Address: 2, Title: map[string]string{"base": "Battery capacity"}, Units: "%"},
+			{Address: 3, Title: map[string]string{"base": "Output source"}, EnumerationStrings: &protocol.Enumeration{
+				Variants: map[protocol.EnumVariant]*string{1: &battery, 0: &line},
+			}},
+			{Address: 4, Title: map[string]string{"base": "Mode"}, EnumerationStrings: &protocol.Enumeration{External: &external}},
+			{Address: 5, Title: map[string]string{"base": "Firmware"}, ValueType: protocol.ValueTypeBCD},
+			{Address: 6, Title: map[string]string{"base": "AC output load"}, Units: "%"},
+		},
+		OtherCodes: map[string]protocol.ExternEnum{
+			"modes": {Variants: map[int]string{2: "Standby", 1: "Fault"}},
+		},
+	}
+
+	topics := topicNames{prefix: "openess"}
+	info := commands.DeviceInfoResult{SerialNumber: "AB 12", Manufacturer: "PowMr", DeviceType: "0925"}
+
+	topic, sensor := newDiscoverySensor("homeassistant", topics, &desc, &info, "output_voltage", &desc.Root[0])
+
+	if topic != "homeassistant/sensor/openess_AB_12/output_voltage/config" {
+		t.Fatalf("unexpected topic %s", topic)
+	}
+
+	if sensor.DeviceClass != "voltage" || sensor.UnitOfMeasurement != "V" || sensor.StateClass != "measurement" ||
+		sensor.StateTopic != "openess/register/output_voltage" || sensor.UniqueId != "openess_AB_12_output_voltage" ||
+		sensor.Device.Manufacturer != "PowMr" || sensor.Device.Identifiers[0] != "openess_AB_12" {
+		t.Fatalf("unexpected sensor %+v", sensor)
+	}
+
+	_, sensor = newDiscoverySensor("homeassistant", topics, &desc, &info, "soc", &desc.Root[1])
+	if sensor.DeviceClass != "battery" {
+		t.Fatalf("unexpected device class %s", sensor.DeviceClass)
+	}
+
+	_, sensor = newDiscoverySensor("homeassistant", topics, &desc, nil, "source", &desc.Root[2])
+	if sensor.DeviceClass != "enum" || len(sensor.Options) != 2 || sensor.Options[0] != "Line" || sensor.StateClass != "" ||
+		sensor.ValueTemplate != `{{ value if value in ["Line","Battery"] else None }}` {
+		t.Fatalf("unexpected enum sensor %+v", sensor)
+	}
+
+	_, sensor = newDiscoverySensor("homeassistant", topics, &desc, nil, "mode", &desc.Root[3])
+	if len(sensor.Options) != 2 || sensor.Options[0] != "Fault" || sensor.Options[1] != "Standby" {
+		t.Fatalf("unexpected external enum sensor %+v", sensor)
+	}
+
+	_, sensor = newDiscoverySensor("homeassistant", topics, &desc, nil, "firmware", &desc.Root[4])
+	if sensor.DeviceClass != "" || sensor.StateClass != "" || sensor.UnitOfMeasurement != "" {
+		t.Fatalf("unexpected string sensor %+v", sensor)
+	}
+
+	_, sensor = newDiscoverySensor("homeassistant", topics, &desc, nil, "load", &desc.Root[5])
+	if sensor.DeviceClass != "" || sensor.UnitOfMeasurement != "%" || sensor.StateClass != "measurement" {
+		t.Fatalf("unexpected percent sensor %+v", sensor)
+	}
+}
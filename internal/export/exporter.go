@@ -0,0 +1,24 @@
+package export
+
+import (
+	"openess/internal/collector"
+)
+
+// Sink of collector events. Every exporter gets its own subscription, so exporters
+// can run side by side without stealing each other's updates.
+type Exporter interface {
+	// Starts exporting events of the subscription in background
+	Start(sub *collector.Subscription) error
+}
+
+// Subscribes exporters to the collector and starts them
+func StartExporters(col *collector.Collector, exporters []Exporter) error {
+	for _, exporter := range exporters {
+		err := exporter.Start(col.Subscribe(collector.DefaultSubscriptionBuffer))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
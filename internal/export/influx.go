@@ -0,0 +1,406 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"openess/internal/collector"
+	"openess/internal/commands"
+	"openess/internal/log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InfluxDB export config. v2 write API is used if Bucket is set, v1 otherwise.
+type InfluxConfig struct {
+	Enabled bool
+	// Server address, e.g. http://127.0.0.1:8086
+	URL string
+	// v2 API options
+	Org    string
+	Bucket string
+	Token  string
+	// v1 API options
+	Database        string
+	RetentionPolicy string
+	User            string
+	Password        string
+	// Measurement name, "openess" if empty
+	Measurement string
+	// Extra tags added to every point
+	Tags map[string]string
+	// Max number of points written with a single request, 500 if zero
+	BatchSize int
+	// Interval of writing collected points, "10s" if empty
+	FlushInterval string
+	// File to keep unwritten points in while the server is unreachable, memory if empty
+	BufferPath string
+	// Max number of unwritten points, the oldest ones are dropped, 100000 if zero
+	MaxBufferSize int
+}
+
+const (
+	DefaultInfluxMeasurement   = "openess"
+	DefaultInfluxBatchSize     = 500
+	DefaultInfluxFlushInterval = 10 * time.Second
+	DefaultInfluxMaxBufferSize = 100000
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+	influxTagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+	influxStringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// Unwritten points, persisted to the file if path is set
+type influxBuffer struct {
+	path  string
+	lines []string
+	max   int
+	// buffer file is not empty
+	onDisk bool
+}
+
+type influxExporter struct {
+	config        InfluxConfig
+	writeUrl      string
+	flushInterval time.Duration
+	http          *http.Client
+	buffer        *influxBuffer
+	// update times of the last written register values
+	written map[string]time.Time
+}
+
+func loadInfluxBuffer(path string, max int) (*influxBuffer, error) {
+	buffer := influxBuffer{path: path, max: max}
+
+	if path == "" {
+		return &buffer, nil
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &buffer, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			buffer.lines = append(buffer.lines, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	buffer.trim()
+	buffer.onDisk = len(buffer.lines) > 0
+
+	return &buffer, nil
+}
+
+func (this *influxBuffer) trim() {
+	if len(this.lines) > this.max {
+		log.PrError("export:influx: buffer is full, dropping %d oldest points\n", len(this.lines)-this.max)
+		this.lines = this.lines[len(this.lines)-this.max:]
+	}
+}
+
+func (this *influxBuffer) push(lines []string) {
+	this.lines = append(this.lines, lines...)
+	this.trim()
+}
+
+func (this *influxBuffer) drop(n int) {
+	this.lines = this.lines[n:]
+}
+
+// Writes buffered lines to the file, does nothing if both the buffer and the file are empty
+func (this *influxBuffer) persist() error {
+	if this.path == "" || (len(this.lines) == 0 && !this.onDisk) {
+		return nil
+	}
+
+	tmpPath := this.path + ".tmp"
+
+	data := strings.Join(this.lines, "\n")
+	if data != "" {
+		data += "\n"
+	}
+
+	if err := os.WriteFile(tmpPath, []byte(data), 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, this.path); err != nil {
+		return err
+	}
+
+	this.onDisk = len(this.lines) > 0
+
+	return nil
+}
+
+func newInfluxWriteUrl(config InfluxConfig) (string, error) {
+	base, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil {
+		return "", err
+	}
+
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return "", errors.New(fmt.Sprintf("invalid InfluxDB url %q", config.URL))
+	}
+
+	query := url.Values{}
+	query.Set("precision", "ns")
+
+	if config.Bucket != "" {
+		base.Path += "/api/v2/write"
+		query.Set("bucket", config.Bucket)
+		if config.Org != "" {
+			query.Set("org", config.Org)
+		}
+	} else if config.Database != "" {
+		base.Path += "/write"
+		query.Set("db", config.Database)
+		if config.RetentionPolicy != "" {
+			query.Set("rp", config.RetentionPolicy)
+		}
+	} else {
+		return "", errors.New("either Bucket or Database must be specified")
+	}
+
+	base.RawQuery = query.Encode()
+
+	return base.String(), nil
+}
+
+// Creates exporter writing polled values to InfluxDB
+func NewInfluxExporter(config InfluxConfig) (Exporter, error) {
+	writeUrl, err := newInfluxWriteUrl(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Measurement == "" {
+		config.Measurement = DefaultInfluxMeasurement
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultInfluxBatchSize
+	}
+	if config.MaxBufferSize <= 0 {
+		config.MaxBufferSize = DefaultInfluxMaxBufferSize
+	}
+
+	flushInterval := DefaultInfluxFlushInterval
+	if config.FlushInterval != "" {
+		flushInterval, err = time.ParseDuration(config.FlushInterval)
+		if err != nil {
+			return nil, err
+		}
+		if flushInterval <= 0 {
+			return nil, errors.New("flush interval must be positive")
+		}
+	}
+
+	buffer, err := loadInfluxBuffer(config.BufferPath, config.MaxBufferSize)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter := influxExporter{
+		config:        config,
+		writeUrl:      writeUrl,
+		flushInterval: flushInterval,
+		http:          &http.Client{Timeout: 10 * time.Second},
+		buffer:        buffer,
+		written:       make(map[string]time.Time),
+	}
+
+	return &exporter, nil
+}
+
+// Returns field with register value: value (float) for numeric registers, text (string)
+// for other ones. Fields have different names as a field can't have several types.
+func influxValueField(value *commands.RegValue) string {
+	if _, ok := value.Number(); ok {
+		return "value=" + value.ToStringRaw()
+	}
+
+	return `text="` + influxStringEscaper.Replace(value.ToStringRaw()) + `"`
+}
+
+// Converts register value to a line protocol point
+func (this *influxExporter) formatPoint(exportId string, v *collector.PolledRegister) string {
+	tags := map[string]string{}
+	for k, val := range this.config.Tags {
+		tags[k] = val
+	}
+
+	tags["id"] = exportId
+	tags["name"] = v.Register.Name("base")
+	tags["units"] = v.Register.Units
+	if v.LastValue.Units != nil {
+		tags["units"] = *v.LastValue.Units
+	}
+
+	keys := []string{}
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	line := strings.Builder{}
+	line.WriteString(influxMeasurementEscaper.Replace(this.config.Measurement))
+
+	for _, k := range keys {
+		// empty tag values are not allowed
+		if tags[k] == "" {
+			continue
+		}
+		fmt.Fprintf(&line, ",%s=%s", influxTagEscaper.Replace(k), influxTagEscaper.Replace(tags[k]))
+	}
+
+	fmt.Fprintf(&line, " %s,raw=%di", influxValueField(v.LastValue), v.LastValue.ValueRaw)
+
+	if label := v.LastValue.ValueLabel; label != nil {
+		fmt.Fprintf(&line, `,label="%s"`, influxStringEscaper.Replace(*label))
+	}
+
+	fmt.Fprintf(&line, " %d", v.LastUpdate.UnixNano())
+
+	return line.String()
+}
+
+// Converts registers updated since the last call to line protocol points
+func (this *influxExporter) formatState(state collector.PollState) []string {
+	ids := []string{}
+	for exportId := range state {
+		ids = append(ids, exportId)
+	}
+	sort.Strings(ids)
+
+	lines := []string{}
+
+	for _, exportId := range ids {
+		v := state[exportId]
+		if v.LastValue == nil || !v.LastUpdate.After(this.written[exportId]) {
+			continue
+		}
+
+		lines = append(lines, this.formatPoint(exportId, v))
+		this.written[exportId] = v.LastUpdate
+	}
+
+	return lines
+}
+
+type influxWriteError struct {
+	status int
+	body   string
+}
+
+func (this influxWriteError) Error() string {
+	return fmt.Sprintf("write failed with status %d: %s", this.status, this.body)
+}
+
+func (this *influxExporter) write(lines []string) error {
+	body := strings.Join(lines, "\n") + "\n"
+
+	req, err := http.NewRequest(http.MethodPost, this.writeUrl, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	if this.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+this.config.Token)
+	} else if this.config.User != "" {
+		req.SetBasicAuth(this.config.User, this.config.Password)
+	}
+
+	resp, err := this.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return influxWriteError{status: resp.StatusCode, body: strings.TrimSpace(string(data))}
+	}
+
+	return nil
+}
+
+// Writes buffered points in batches, points are kept in the buffer until they are written
+func (this *influxExporter) flush() {
+	for len(this.buffer.lines) > 0 {
+		n := min(len(this.buffer.lines), this.config.BatchSize)
+
+		err := this.write(this.buffer.lines[:n])
+
+		var writeErr influxWriteError
+		if errors.As(err, &writeErr) && writeErr.status == http.StatusBadRequest {
+			// malformed points will never be accepted
+			log.PrError("export:influx: dropping %d points: %s\n", n, err)
+		} else if err != nil {
+			log.PrError("export:influx: %s, %d points are buffered\n", err, len(this.buffer.lines))
+			break
+		} else {
+			log.PrDebug("export:influx: written %d points\n", n)
+		}
+
+		this.buffer.drop(n)
+	}
+
+	if err := this.buffer.persist(); err != nil {
+		log.PrError("export:influx: failed to save buffer %s: %s\n", this.buffer.path, err)
+	}
+}
+
+func (this *influxExporter) eventLoop(sub *collector.Subscription) {
+	ticker := time.NewTicker(this.flushInterval)
+	pending := 0
+
+	for {
+		select {
+		case state := <-sub.State:
+			lines := this.formatState(state)
+			this.buffer.push(lines)
+			pending += len(lines)
+
+			if pending < this.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-sub.Conn:
+			continue
+		case <-sub.Flow:
+			continue
+		}
+
+		pending = 0
+		this.flush()
+	}
+}
+
+func (this *influxExporter) Start(sub *collector.Subscription) error {
+	log.PrInfo("export:influx: writing to %s\n", this.config.URL)
+
+	go this.eventLoop(sub)
+
+	return nil
+}
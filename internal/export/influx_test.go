@@ -0,0 +1,214 @@
+package export
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"openess/internal/collector"
+	"openess/internal/commands"
+	"openess/internal/log"
+	"openess/internal/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type influxStandIn struct {
+	mtx      sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	status   int
+}
+
+func (this *influxStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+
+	this.requests = append(this.requests, r)
+	this.bodies = append(this.bodies, string(body))
+
+	w.WriteHeader(this.status)
+}
+
+func (this *influxStandIn) setStatus(status int) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+
+	this.status = status
+}
+
+func newInfluxTestState(voltage float32, at time.Time) collector.PollState {
+	mode := "Line"
+	units := "V"
+	digits := 1
+
+	regs := []protocol.Register{
+		{Address: 1, Title: map[string]string{"base": "Output voltage"}, Units: "V"},
+		{Address: 2, Title: map[string]string{"base": "Working mode"}},
+	}
+
+	return collector.PollState{
+		"voltage": {
+			Register:   &regs[0],
+			LastValue:  &commands.RegValue{Type: commands.RegTypeFloat, ValueRaw: uint32(voltage * 10), ValueFloat: &voltage, Units: &units, Digits: &digits},
+			LastUpdate: at,
+		},
+		"mode": {
+			Register:   &regs[1],
+			LastValue:  &commands.RegValue{Type: commands.RegTypeEnum, ValueRaw: 2, ValueEnum: &mode},
+			LastUpdate: at,
+		},
+		"unread": {
+			Register: &regs[1],
+		},
+	}
+}
+
+func TestInfluxLines(t *testing.T) {
+	exporter, err := NewInfluxExporter(InfluxConfig{
+		URL:         "http://127.0.0.1:8086",
+		Database:    "inverter",
+		Measurement: "inverter data",
+		Tags:        map[string]string{"site": "garage, north"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create exporter: %s", err)
+	}
+
+	influx := exporter.(*influxExporter)
+
+	at := time.Unix(1700000000, 5)
+	lines := influx.formatState(newInfluxTestState(230.1, at))
+
+	expected := []string{
+		`inverter\ data,id=mode,name=Working\ mode,site=garage\,\ north text="Line",raw=2i 1700000000000000005`,
+		`inverter\ data,id=voltage,name=Output\ voltage,site=garage\,\ north,units=V value=230.1,raw=2301i 1700000000000000005`,
+	}
+
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected lines:\n%s", strings.Join(lines, "\n"))
+	}
+
+	if lines := influx.formatState(newInfluxTestState(230.1, at)); len(lines) != 0 {
+		t.Fatalf("values not updated since the last call should be skipped: %v", lines)
+	}
+
+	if influx.writeUrl != "http://127.0.0.1:8086/write?db=inverter&precision=ns" {
+		t.Fatalf("unexpected write url %s", influx.writeUrl)
+	}
+
+	if _, err := NewInfluxExporter(InfluxConfig{URL: "http://127.0.0.1:8086"}); err == nil {
+		t.Fatalf("expected an error without database and bucket")
+	}
+}
+
+func TestInfluxWrite(t *testing.T) {
+	log.Init(log.LOG_OFF)
+
+	standIn := &influxStandIn{status: http.StatusNoContent}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	bufferPath := filepath.Join(t.TempDir(), "influx.buffer")
+
+	exporter, err := NewInfluxExporter(InfluxConfig{
+		URL:        server.URL,
+		Org:        "home",
+		Bucket:     "inverter",
+		Token:      "secret",
+		BatchSize:  3,
+		BufferPath: bufferPath,
+	})
+	if err != nil {
+		t.Fatalf("failed to create exporter: %s", err)
+	}
+
+	influx := exporter.(*influxExporter)
+	at := time.Unix(1700000000, 0)
+
+	// server is down, points are kept on disk
+	standIn.setStatus(http.StatusServiceUnavailable)
+
+	for i := 0; i < 2; i++ {
+		influx.buffer.push(influx.formatState(newInfluxTestState(float32(230+i), at.Add(time.Duration(i)*time.Second))))
+		influx.flush()
+	}
+
+	data, err := os.ReadFile(bufferPath)
+	if err != nil || strings.Count(string(data), "\n") != 4 {
+		t.Fatalf("expected 4 buffered points, got %q (%v)", data, err)
+	}
+
+	// buffer survives restart
+	exporter, err = NewInfluxExporter(influx.config)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %s", err)
+	}
+
+	influx = exporter.(*influxExporter)
+	if len(influx.buffer.lines) != 4 {
+		t.Fatalf("expected 4 loaded points, got %d", len(influx.buffer.lines))
+	}
+
+	standIn.setStatus(http.StatusNoContent)
+	influx.flush()
+
+	if len(standIn.requests) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(standIn.requests))
+	}
+
+	req := standIn.requests[2]
+	if req.URL.Path != "/api/v2/write" || req.URL.Query().Get("bucket") != "inverter" ||
+		req.URL.Query().Get("org") != "home" || req.Header.Get("Authorization") != "Token secret" {
+		t.Fatalf("unexpected request %s %v", req.URL, req.Header)
+	}
+
+	if strings.Count(standIn.bodies[2], "\n") != 3 || strings.Count(standIn.bodies[3], "\n") != 1 {
+		t.Fatalf("unexpected batches %q %q", standIn.bodies[2], standIn.bodies[3])
+	}
+
+	if data, err := os.ReadFile(bufferPath); err != nil || len(data) != 0 {
+		t.Fatalf("expected empty buffer file, got %q (%v)", data, err)
+	}
+}
+
+func TestInfluxV1Auth(t *testing.T) {
+	log.Init(log.LOG_OFF)
+
+	standIn := &influxStandIn{status: http.StatusBadRequest}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	exporter, err := NewInfluxExporter(InfluxConfig{
+		URL:      server.URL,
+		Database: "inverter",
+		User:     "user",
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatalf("failed to create exporter: %s", err)
+	}
+
+	influx := exporter.(*influxExporter)
+	influx.buffer.push(influx.formatState(newInfluxTestState(230, time.Unix(1700000000, 0))))
+	influx.flush()
+
+	if len(standIn.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(standIn.requests))
+	}
+
+	user, password, ok := standIn.requests[0].BasicAuth()
+	if !ok || user != "user" || password != "password" || standIn.requests[0].URL.Path != "/write" {
+		t.Fatalf("unexpected request %s %v", standIn.requests[0].URL, standIn.requests[0].Header)
+	}
+
+	// rejected points are not retried
+	if len(influx.buffer.lines) != 0 {
+		t.Fatalf("expected rejected points to be dropped")
+	}
+}
@@ -1,9 +1,13 @@
 package export
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"openess/internal/client"
 	"openess/internal/collector"
 	"openess/internal/log"
+	"strings"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -14,12 +18,67 @@ type Config struct {
 	ClientId *string
 	User     *string
 	Password *string
+	// TLS options of ssl:// broker address (optional)
+	TLS *TLSConfig
+	// Publish registers only on change, every poll if not set
+	OnChange  *OnChangeConfig
+	Discovery DiscoveryConfig
+	// Publish JSON document with all registers at {prefix}/state every poll
+	PublishState bool
+	// Accept register writes on {prefix}/register/{name}/set topics
+	AllowWrite bool
+	// Topic prefix, "openess" if empty
+	TopicPrefix string
+	QoS         byte
+	// Retain register and flow messages. Status messages are always retained
+	// as they are overwritten by the retained last will.
+	Retain bool
+}
+
+const DefaultTopicPrefix = "openess"
+
+type topicNames struct {
+	prefix string
+}
+
+func (t topicNames) status() string {
+	return t.prefix + "/status"
+}
+
+func (t topicNames) state() string {
+	return t.prefix + "/state"
+}
+
+func (t topicNames) flow() string {
+	return t.prefix + "/flow"
+}
+
+func (t topicNames) register(name string) string {
+	return fmt.Sprintf("%s/register/%s", t.prefix, name)
+}
+
+func (t topicNames) set(name string) string {
+	return t.register(name) + "/set"
+}
+
+func (t topicNames) setResult(name string) string {
+	return t.set(name) + "/result"
 }
 
 type mqttExporterTask struct {
-	options   *mqtt.ClientOptions
-	client    *mqtt.Client
-	collector *collector.Collector
+	options         *mqtt.ClientOptions
+	client          *mqtt.Client
+	device          *client.Client
+	sub             *collector.Subscription
+	topics          topicNames
+	qos             byte
+	retain          bool
+	filter          *changeFilter
+	discovery       *DiscoveryConfig
+	publishStateDoc bool
+	// last polled state, used to resolve register names of write requests
+	state       collector.PollState
+	setRequests chan setRequest
 }
 
 func (task *mqttExporterTask) connect() error {
@@ -45,15 +104,30 @@ func (task *mqttExporterTask) publishStatus(connState bool) mqtt.Token {
 		status = "online"
 	}
 
-	tok := (*task.client).Publish("openess/status", 0, false, status)
+	tok := (*task.client).Publish(task.topics.status(), task.qos, true, status)
+	tok.Wait()
+
+	return tok
+}
+
+func (task *mqttExporterTask) publishFlows(state collector.FlowState) mqtt.Token {
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.PrError("export:mqtt: failed to encode energy flows: %s\n", err)
+	}
+
+	log.PrInfo("export:mqtt: publishing energy flows: %s\n", data)
+
+	tok := (*task.client).Publish(task.topics.flow(), task.qos, task.retain, data)
 	tok.Wait()
 
 	return tok
 }
 
 func (task *mqttExporterTask) eventLoop() {
-	c := task.collector.GetStateChan()
-	conn := task.collector.GetConnChan()
+	c := task.sub.State
+	conn := task.sub.Conn
+	flows := task.sub.Flow
 
 	for {
 		var backoff time.Duration = time.Second * 2
@@ -74,19 +148,46 @@ func (task *mqttExporterTask) eventLoop() {
 
 		log.PrInfo("export:mqtt: connected to broker\n")
 
+		if task.filter != nil {
+			task.filter.reset()
+		}
+
+		discoveryPublished := false
+		// all registers are published after connecting, then only the refreshed ones
+		publishAll := true
+
 	publish_loop:
 		for {
 			var tok mqtt.Token
 
 			select {
 			case state := <-c:
+				task.state = state
 				tok = task.publishStatus(true)
 
 				if tok.Error() != nil {
 					break
 				}
 
+				if task.discovery != nil && !discoveryPublished {
+					if dtok := task.publishDiscovery(state); dtok != nil && dtok.Error() != nil {
+						tok = dtok
+						break
+					}
+					discoveryPublished = true
+				}
+
+				now := time.Now()
+
 				for n, v := range state {
+					if !v.Refreshed && !publishAll {
+						continue
+					}
+
+					if task.filter != nil && !task.filter.update(n, v.LastValue, now) {
+						continue
+					}
+
 					var valStr string
 
 					if v.LastValue != nil {
@@ -97,15 +198,27 @@ func (task *mqttExporterTask) eventLoop() {
 
 					log.PrInfo("export:mqtt: publishing register: %s = %s\n", n, valStr)
 
-					tok = (*task.client).Publish(fmt.Sprintf("openess/register/%s", n), 0, false, valStr)
+					tok = (*task.client).Publish(task.topics.register(n), task.qos, task.retain, valStr)
 					tok.Wait()
 
 					if tok.Error() != nil {
 						break
 					}
 				}
+
+				if tok.Error() == nil {
+					publishAll = false
+				}
+
+				if tok.Error() == nil && task.publishStateDoc {
+					tok = task.publishState(state)
+				}
 			case connState := <-conn:
 				tok = task.publishStatus(connState)
+			case flowState := <-flows:
+				tok = task.publishFlows(flowState)
+			case req := <-task.setRequests:
+				tok = task.publishSetResult(req, task.writeRegister(req))
 			}
 
 			if tok.Error() != nil {
@@ -117,9 +230,19 @@ func (task *mqttExporterTask) eventLoop() {
 	}
 }
 
-func StartMqttExporter(config Config, col *collector.Collector) {
+func NewMqttExporter(config Config, cli *client.Client) (Exporter, error) {
+	if config.QoS > 2 {
+		return nil, errors.New(fmt.Sprintf("invalid QoS %d", config.QoS))
+	}
+
+	topics := topicNames{prefix: strings.TrimSuffix(config.TopicPrefix, "/")}
+	if topics.prefix == "" {
+		topics.prefix = DefaultTopicPrefix
+	}
+
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(config.Broker)
+	opts.SetWill(topics.status(), "offline", config.QoS, true)
 
 	if config.ClientId != nil {
 		opts.SetClientID(*config.ClientId)
@@ -130,12 +253,49 @@ func StartMqttExporter(config Config, col *collector.Collector) {
 	if config.Password != nil {
 		opts.SetPassword(*config.Password)
 	}
+	if config.TLS != nil {
+		tlsConfig, err := newTLSConfig(*config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	task := &mqttExporterTask{
+		options: opts,
+		client:  nil,
+		device:  cli,
+		topics:  topics,
+		qos:     config.QoS,
+		retain:  config.Retain,
+	}
+
+	if config.OnChange != nil {
+		filter, err := newChangeFilter(*config.OnChange)
+		if err != nil {
+			return nil, err
+		}
+		task.filter = filter
+	}
+
+	if config.Discovery.Enabled {
+		task.discovery = &config.Discovery
+	}
+
+	task.publishStateDoc = config.PublishState
 
-	cli := &mqttExporterTask{
-		options:   opts,
-		client:    nil,
-		collector: col,
+	if config.AllowWrite {
+		task.setRequests = make(chan setRequest, 16)
+		opts.SetOnConnectHandler(task.subscribeSet)
 	}
 
-	go cli.eventLoop()
+	return task, nil
+}
+
+func (task *mqttExporterTask) Start(sub *collector.Subscription) error {
+	task.sub = sub
+
+	go task.eventLoop()
+
+	return nil
 }
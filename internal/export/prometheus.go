@@ -0,0 +1,189 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"openess/internal/collector"
+	"openess/internal/commands"
+	"openess/internal/log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type PrometheusConfig struct {
+	Enabled bool
+	// Listen address of the metrics endpoint, ":9110" if empty
+	Listen string
+}
+
+const DefaultPrometheusListen = ":9110"
+
+// Datalogger connection statistics
+type connStats struct {
+	Connected   bool
+	Disconnects uint64
+}
+
+type prometheusExporter struct {
+	listen string
+	mtx    sync.Mutex
+	state  collector.PollState
+	stats  connStats
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type promLabel struct {
+	name  string
+	value string
+}
+
+func formatPromLabels(labels []promLabel) string {
+	parts := []string{}
+
+	for _, l := range labels {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, l.name, prometheusLabelEscaper.Replace(l.value)))
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func writePromHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Writes metrics in Prometheus text exposition format
+func writePromMetrics(w io.Writer, state collector.PollState, stats connStats) {
+	ids := []string{}
+	for exportId := range state {
+		ids = append(ids, exportId)
+	}
+	sort.Strings(ids)
+
+	registerLabels := func(exportId string, v *collector.PolledRegister) []promLabel {
+		units := v.Register.Units
+		if v.LastValue != nil && v.LastValue.Units != nil {
+			units = *v.LastValue.Units
+		}
+
+		return []promLabel{
+			{"id", exportId},
+			{"name", v.Register.Name("base")},
+			{"units", units},
+			{"address", strconv.FormatUint(uint64(v.Register.Address), 10)},
+		}
+	}
+
+	writePromHeader(w, "openess_register_value", "gauge", "Numeric register value.")
+	for _, exportId := range ids {
+		v := state[exportId]
+		if v.LastValue == nil {
+			continue
+		}
+
+		if num, ok := v.LastValue.Number(); ok {
+			// float registers are float32, format them without float64 conversion artifacts
+			bitSize := 64
+			if v.LastValue.Type == commands.RegTypeFloat {
+				bitSize = 32
+			}
+
+			fmt.Fprintf(w, "openess_register_value%s %s\n",
+				formatPromLabels(registerLabels(exportId, v)), strconv.FormatFloat(num, 'g', -1, bitSize))
+		}
+	}
+
+	writePromHeader(w, "openess_register_info", "gauge", "Enumeration register value, the value is in the value label.")
+	for _, exportId := range ids {
+		v := state[exportId]
+		if v.LastValue == nil || v.LastValue.Type != commands.RegTypeEnum {
+			continue
+		}
+
+		labels := append(registerLabels(exportId, v),
+			promLabel{"value", v.LastValue.ToStringRaw()},
+			promLabel{"raw", strconv.FormatUint(uint64(v.LastValue.ValueRaw), 10)})
+
+		fmt.Fprintf(w, "openess_register_info%s 1\n", formatPromLabels(labels))
+	}
+
+	writePromHeader(w, "openess_register_read_errors_total", "counter", "Number of failed register reads.")
+	for _, exportId := range ids {
+		v := state[exportId]
+		fmt.Fprintf(w, "openess_register_read_errors_total%s %d\n",
+			formatPromLabels(registerLabels(exportId, v)[:1]), v.ErrorCount)
+	}
+
+	connected := 0
+	if stats.Connected {
+		connected = 1
+	}
+
+	writePromHeader(w, "openess_datalogger_connected", "gauge", "Datalogger connection state.")
+	fmt.Fprintf(w, "openess_datalogger_connected %d\n", connected)
+
+	writePromHeader(w, "openess_datalogger_disconnects_total", "counter", "Number of datalogger connection losses.")
+	fmt.Fprintf(w, "openess_datalogger_disconnects_total %d\n", stats.Disconnects)
+}
+
+func (this *prometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writePromMetrics(w, this.state, this.stats)
+}
+
+func (this *prometheusExporter) eventLoop(sub *collector.Subscription) {
+	for {
+		select {
+		case state := <-sub.State:
+			this.mtx.Lock()
+			this.state = state
+			this.mtx.Unlock()
+		case connected := <-sub.Conn:
+			this.mtx.Lock()
+			if this.stats.Connected && !connected {
+				this.stats.Disconnects += 1
+			}
+			this.stats.Connected = connected
+			this.mtx.Unlock()
+		case <-sub.Flow:
+		}
+	}
+}
+
+// Creates exporter serving latest collector state at /metrics endpoint
+func NewPrometheusExporter(config PrometheusConfig) Exporter {
+	listen := config.Listen
+	if listen == "" {
+		listen = DefaultPrometheusListen
+	}
+
+	return &prometheusExporter{listen: listen, state: collector.PollState{}}
+}
+
+func (this *prometheusExporter) Start(sub *collector.Subscription) error {
+	listener, err := net.Listen("tcp", this.listen)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", this)
+
+	log.PrInfo("export:prometheus: serving metrics at %s/metrics\n", listener.Addr())
+
+	go this.eventLoop(sub)
+
+	go func() {
+		err := http.Serve(listener, mux)
+		log.PrError("export:prometheus: server stopped: %s\n", err)
+	}()
+
+	return nil
+}
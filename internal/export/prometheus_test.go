@@ -0,0 +1,54 @@
+package export
+
+import (
+	"bytes"
+	"openess/internal/collector"
+	"openess/internal/commands"
+	"openess/internal/protocol"
+	"testing"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	voltage := float32(230.1)
+	mode := "Line"
+	power := 1200
+
+	regs := []protocol.Register{
+		{Address: 201, Title: map[string]string{"base": "Output voltage"}, Units: "V"},
+		{Address: 202, Title: map[string]string{"base": "Working \"mode\""}},
+		{Address: 203, Title: map[string]string{"base": "Output power"}, Units: "W"},
+	}
+
+	state := collector.PollState{
+		"voltage": {Register: &regs[0], LastValue: &commands.RegValue{Type: commands.RegTypeFloat, ValueFloat: &voltage}},
+		"mode":    {Register: &regs[1], LastValue: &commands.RegValue{Type: commands.RegTypeEnum, ValueRaw: 2, ValueEnum: &mode}, ErrorCount: 1},
+		"power":   {Register: &regs[2], LastValue: &commands.RegValue{Type: commands.RegTypeInt, ValueInt: &power}},
+	}
+
+	buf := bytes.Buffer{}
+	writePromMetrics(&buf, state, connStats{Connected: true, Disconnects: 3})
+
+	expected := `# HELP openess_register_value Numeric register value.
+# TYPE openess_register_value gauge
+openess_register_value{id="power",name="Output power",units="W",address="203"} 1200
+openess_register_value{id="voltage",name="Output voltage",units="V",address="201"} 230.1
+# HELP openess_register_info Enumeration register value, the value is in the value label.
+# TYPE openess_register_info gauge
+openess_register_info{id="mode",name="Working \"mode\"",units="",address="202",value="Line",raw="2"} 1
+# HELP openess_register_read_errors_total Number of failed register reads.
+# TYPE openess_register_read_errors_total counter
+openess_register_read_errors_total{id="mode"} 1
+openess_register_read_errors_total{id="power"} 0
+openess_register_read_errors_total{id="voltage"} 0
+# HELP openess_datalogger_connected Datalogger connection state.
+# TYPE openess_datalogger_connected gauge
+openess_datalogger_connected 1
+# HELP openess_datalogger_disconnects_total Number of datalogger connection losses.
+# TYPE openess_datalogger_disconnects_total counter
+openess_datalogger_disconnects_total 3
+`
+
+	if buf.String() != expected {
+		t.Fatalf("unexpected metrics:\n%s", buf.String())
+	}
+}
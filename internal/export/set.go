@@ -0,0 +1,117 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"openess/internal/client"
+	"openess/internal/commands"
+	"openess/internal/log"
+	"openess/internal/protocol"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Register write request received on {prefix}/register/{name}/set topic
+type setRequest struct {
+	Name  string
+	Value string
+}
+
+type setResult struct {
+	Value  string `json:"value"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Returns register name of a set topic, false if the topic is not a set topic
+func (t topicNames) parseSet(topic string) (string, bool) {
+	name, ok := strings.CutPrefix(topic, t.prefix+"/register/")
+	if !ok {
+		return "", false
+	}
+
+	name, ok = strings.CutSuffix(name, "/set")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+
+	return name, true
+}
+
+func (task *mqttExporterTask) onSetMessage(_ mqtt.Client, msg mqtt.Message) {
+	name, ok := task.topics.parseSet(msg.Topic())
+	if !ok {
+		log.PrError("export:mqtt: unexpected set topic %s\n", msg.Topic())
+		return
+	}
+
+	log.PrInfo("export:mqtt: got write request: %s = %s\n", name, msg.Payload())
+
+	select {
+	case task.setRequests <- setRequest{Name: name, Value: string(msg.Payload())}:
+	default:
+		log.PrError("export:mqtt: too many write requests, dropping %s\n", name)
+	}
+}
+
+func (task *mqttExporterTask) subscribeSet(c mqtt.Client) {
+	tok := c.Subscribe(task.topics.set("+"), task.qos, task.onSetMessage)
+	tok.Wait()
+
+	if tok.Error() != nil {
+		log.PrError("export:mqtt: failed to subscribe to set topics: %s\n", tok.Error())
+	}
+}
+
+// Validates the value against descriptor and writes the register. Name is either a polled
+// register export id or a register name from descriptor.
+func (task *mqttExporterTask) writeRegister(req setRequest) error {
+	desc := task.device.GetDescriptor()
+	if desc == nil {
+		return errors.New("descriptor is not loaded")
+	}
+
+	var reg *protocol.Register
+
+	if polled, ok := task.state[req.Name]; ok {
+		reg = polled.Register
+	} else {
+		loc, err := desc.ResolveRegister(req.Name)
+		if err != nil {
+			return err
+		}
+		reg = loc.Register
+	}
+
+	seg, err := commands.FindEditableSegment(desc, reg)
+	if err != nil {
+		return err
+	}
+
+	value, err := commands.ParseWriteValue(desc, reg, req.Value)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.SendCommand(task.device, commands.NewRegWriteDescr(seg, reg, value))
+
+	return err
+}
+
+func (task *mqttExporterTask) publishSetResult(req setRequest, err error) mqtt.Token {
+	result := setResult{Value: req.Value, Status: "ok"}
+
+	if err != nil {
+		log.PrError("export:mqtt: failed to write register %s: %s\n", req.Name, err)
+		result.Status = "error"
+		result.Error = err.Error()
+	}
+
+	data, _ := json.Marshal(result)
+
+	tok := (*task.client).Publish(task.topics.setResult(req.Name), task.qos, false, data)
+	tok.Wait()
+
+	return tok
+}
@@ -0,0 +1,39 @@
+package export
+
+import (
+	"testing"
+)
+
+func TestParseSetTopic(t *testing.T) {
+	tests := map[string]string{
+		"openess/register/output_priority/set": "output_priority",
+		"openess/register/Charger Source/set":  "Charger Source",
+		"openess/register/output_priority":     "",
+		"openess/register//set":                "",
+		"openess/register/a/b/set":             "",
+		"other/register/a/set":                 "",
+	}
+
+	for topic, expected := range tests {
+		name, ok := topicNames{prefix: "openess"}.parseSet(topic)
+		if name != expected || ok != (expected != "") {
+			t.Fatalf("%s: %q != %q", topic, expected, name)
+		}
+	}
+}
+
+func TestTopicNames(t *testing.T) {
+	topics := topicNames{prefix: "home/inverter1"}
+
+	if topics.status() != "home/inverter1/status" || topics.setResult("mode") != "home/inverter1/register/mode/set/result" {
+		t.Fatalf("unexpected topics %s %s", topics.status(), topics.setResult("mode"))
+	}
+
+	if name, ok := topics.parseSet(topics.set("mode")); !ok || name != "mode" {
+		t.Fatalf("failed to parse set topic")
+	}
+
+	if _, ok := topics.parseSet("openess/register/mode/set"); ok {
+		t.Fatalf("unexpected set topic match")
+	}
+}
@@ -0,0 +1,98 @@
+package export
+
+import (
+	"encoding/json"
+	"openess/internal/collector"
+	"openess/internal/commands"
+	"openess/internal/log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type stateRegister struct {
+	// Number for int and float registers, list of names for flags, string otherwise
+	Value     any        `json:"value"`
+	Raw       *uint32    `json:"raw"`
+	Units     string     `json:"units,omitempty"`
+	Label     *string    `json:"label,omitempty"`
+	Timestamp *time.Time `json:"timestamp"`
+	Ok        bool       `json:"ok"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Snapshot of all polled registers published at {prefix}/state
+type stateDocument struct {
+	Timestamp time.Time                `json:"timestamp"`
+	Registers map[string]stateRegister `json:"registers"`
+}
+
+func newStateRegister(v *collector.PolledRegister) stateRegister {
+	reg := stateRegister{
+		Units: v.Register.Units,
+		Ok:    v.LastValue != nil && v.LastError == nil,
+	}
+
+	if v.LastError != nil {
+		reg.Error = v.LastError.Error()
+	} else if v.LastValue == nil {
+		reg.Error = "not read yet"
+	}
+
+	if v.LastValue == nil {
+		return reg
+	}
+
+	value := v.LastValue
+	timestamp := v.LastUpdate
+	reg.Timestamp = &timestamp
+	reg.Raw = &value.ValueRaw
+
+	if _, ok := value.Number(); ok {
+		// keeps register digits
+		reg.Value = json.RawMessage(value.ToStringRaw())
+	} else if value.Type == commands.RegTypeFlags {
+		reg.Value = value.ValueFlags
+	} else {
+		reg.Value = value.ToStringRaw()
+	}
+
+	if value.Type == commands.RegTypeEnum {
+		reg.Label = value.ValueEnum
+	} else {
+		reg.Label = value.ValueLabel
+	}
+
+	if value.Units != nil {
+		reg.Units = *value.Units
+	}
+
+	return reg
+}
+
+func newStateDocument(state collector.PollState, now time.Time) stateDocument {
+	doc := stateDocument{
+		Timestamp: now,
+		Registers: make(map[string]stateRegister),
+	}
+
+	for n, v := range state {
+		doc.Registers[n] = newStateRegister(v)
+	}
+
+	return doc
+}
+
+func (task *mqttExporterTask) publishState(state collector.PollState) mqtt.Token {
+	data, err := json.Marshal(newStateDocument(state, time.Now()))
+	if err != nil {
+		log.PrError("export:mqtt: failed to encode state: %s\n", err)
+	}
+
+	log.PrDebug("export:mqtt: publishing state: %s\n", data)
+
+	tok := (*task.client).Publish(task.topics.state(), task.qos, task.retain, data)
+	tok.Wait()
+
+	return tok
+}
@@ -0,0 +1,57 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"openess/internal/collector"
+	"openess/internal/commands"
+	"openess/internal/protocol"
+	"testing"
+	"time"
+)
+
+func TestStateDocument(t *testing.T) {
+	voltage := float32(230.1)
+	digits := 1
+	mode := "Line"
+	units := "V"
+
+	regs := []protocol.Register{
+		{Address: 1, Units: "V"},
+		{Address: 2},
+		{Address: 3},
+	}
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	state := collector.PollState{
+		"voltage": {
+			Register:   &regs[0],
+			LastValue:  &commands.RegValue{Type: commands.RegTypeFloat, ValueRaw: 2301, ValueFloat: &voltage, Digits: &digits, Units: &units},
+			LastUpdate: now,
+		},
+		"mode": {
+			Register:   &regs[1],
+			LastValue:  &commands.RegValue{Type: commands.RegTypeEnum, ValueRaw: 2, ValueEnum: &mode},
+			LastUpdate: now,
+			LastError:  errors.New("timeout"),
+		},
+		"faults": {
+			Register: &regs[2],
+		},
+	}
+
+	data, err := json.Marshal(newStateDocument(state, now))
+	if err != nil {
+		t.Fatalf("failed to encode state: %s", err)
+	}
+
+	expected := `{"timestamp":"2024-01-02T03:04:05Z","registers":{` +
+		`"faults":{"value":null,"raw":null,"timestamp":null,"ok":false,"error":"not read yet"},` +
+		`"mode":{"value":"Line","raw":2,"label":"Line","timestamp":"2024-01-02T03:04:05Z","ok":false,"error":"timeout"},` +
+		`"voltage":{"value":230.1,"raw":2301,"units":"V","timestamp":"2024-01-02T03:04:05Z","ok":true}}}`
+
+	if string(data) != expected {
+		t.Fatalf("%s != %s", expected, data)
+	}
+}
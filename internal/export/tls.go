@@ -0,0 +1,58 @@
+package export
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Broker TLS config, used with ssl:// (tls://) broker addresses
+type TLSConfig struct {
+	// PEM bundle of CA certificates to verify broker with, system CAs if empty
+	CAFile string
+	// PEM client certificate and key files for mutual auth (optional)
+	CertFile string
+	KeyFile  string
+	// Broker name to verify the certificate against, broker host if empty
+	ServerName string
+	// Don't verify broker certificate
+	Insecure bool
+}
+
+func newTLSConfig(config TLSConfig) (*tls.Config, error) {
+	result := tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.Insecure,
+	}
+
+	if config.CAFile != "" {
+		data, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New(fmt.Sprintf("no certificates found in %s", config.CAFile))
+		}
+
+		result.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, errors.New("both client certificate and key files must be specified")
+		}
+
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Certificates = []tls.Certificate{cert}
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,201 @@
+package export
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{name},
+	}
+
+	signer, signerKey := &template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %s", err)
+	}
+
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) write(t *testing.T, dir string, name string) (string, string) {
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	if err := os.WriteFile(certPath, certPem, 0600); err != nil {
+		t.Fatalf("failed to write certificate: %s", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("failed to encode key: %s", err)
+	}
+
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(keyPath, keyPem, 0600); err != nil {
+		t.Fatalf("failed to write key: %s", err)
+	}
+
+	return certPath, keyPath
+}
+
+// Starts a TLS listener accepting MQTT connections from clients with certificates
+// signed by the CA. Returns broker address.
+func startTestBroker(t *testing.T, ca *testCert, server *testCert) string {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	config := tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.der}, PrivateKey: server.key}},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &config)
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				// fixed header of CONNECT packet followed by remaining length (< 128 for the test client)
+				header := make([]byte, 2)
+				if _, err := io.ReadFull(conn, header); err != nil || header[0] != 0x10 {
+					return
+				}
+				if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+					return
+				}
+
+				// CONNACK: session not present, connection accepted
+				conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+
+				io.Copy(io.Discard, conn)
+			}(conn)
+		}
+	}()
+
+	return "ssl://" + listener.Addr().String()
+}
+
+func connectTestBroker(broker string, config TLSConfig) error {
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return err
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(broker)
+	opts.SetTLSConfig(tlsConfig)
+	opts.SetConnectTimeout(5 * time.Second)
+	opts.SetAutoReconnect(false)
+
+	client := mqtt.NewClient(opts)
+
+	tok := client.Connect()
+	tok.Wait()
+
+	if tok.Error() == nil {
+		client.Disconnect(0)
+	}
+
+	return tok.Error()
+}
+
+func TestTLSConnection(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, "openess test CA", nil, true)
+	server := newTestCert(t, "broker.local", ca, false)
+	client := newTestCert(t, "openess", ca, false)
+	other := newTestCert(t, "other CA", nil, true)
+
+	caPath, _ := ca.write(t, dir, "ca")
+	certPath, keyPath := client.write(t, dir, "client")
+	otherCertPath, otherKeyPath := newTestCert(t, "openess", other, false).write(t, dir, "other")
+
+	broker := startTestBroker(t, ca, server)
+
+	valid := TLSConfig{CAFile: caPath, CertFile: certPath, KeyFile: keyPath, ServerName: "broker.local"}
+
+	if err := connectTestBroker(broker, valid); err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+
+	insecure := TLSConfig{CertFile: certPath, KeyFile: keyPath, Insecure: true}
+
+	if err := connectTestBroker(broker, insecure); err != nil {
+		t.Fatalf("failed to connect without verification: %s", err)
+	}
+
+	invalid := map[string]TLSConfig{
+		"no client certificate":   {CAFile: caPath, ServerName: "broker.local"},
+		"untrusted client":        {CAFile: caPath, CertFile: otherCertPath, KeyFile: otherKeyPath, ServerName: "broker.local"},
+		"server name mismatch":    {CAFile: caPath, CertFile: certPath, KeyFile: keyPath, ServerName: "other.local"},
+		"untrusted broker":        {CertFile: certPath, KeyFile: keyPath, ServerName: "broker.local"},
+		"missing client key file": {CAFile: caPath, CertFile: certPath},
+	}
+
+	for name, config := range invalid {
+		if err := connectTestBroker(broker, config); err == nil {
+			t.Fatalf("%s: expected connection error", name)
+		}
+	}
+
+	if _, err := newTLSConfig(TLSConfig{CAFile: keyPath}); err == nil {
+		t.Fatalf("expected an error for CA file without certificates")
+	}
+}
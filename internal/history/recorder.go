@@ -0,0 +1,147 @@
+package history
+
+import (
+	"errors"
+	"openess/internal/collector"
+	"openess/internal/log"
+	"sort"
+	"time"
+)
+
+// Local history config
+type Config struct {
+	Enabled bool
+	// Directory of history files
+	Path string
+	// Max age of stored values, e.g. "30d", values are kept forever if empty
+	Retention string
+	// Age of values to downsample, e.g. "7d", values are not downsampled if empty
+	DownsampleAfter string
+	// Interval of downsampled values, "5m" if empty
+	DownsampleStep string
+}
+
+const (
+	DefaultDownsampleStep = 5 * time.Minute
+	maintenanceInterval   = time.Hour
+)
+
+// Exporter recording polled values to the local store
+type Recorder struct {
+	store           *Store
+	retention       time.Duration
+	downsampleAfter time.Duration
+	downsampleStep  time.Duration
+	// update times of the last recorded register values
+	written map[string]time.Time
+}
+
+func parseOptionalDuration(s string, fallback time.Duration) (time.Duration, error) {
+	if s == "" {
+		return fallback, nil
+	}
+
+	return ParseDuration(s)
+}
+
+func NewRecorder(config Config) (*Recorder, error) {
+	if config.Path == "" {
+		return nil, errors.New("history path must be specified")
+	}
+
+	retention, err := parseOptionalDuration(config.Retention, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	downsampleAfter, err := parseOptionalDuration(config.DownsampleAfter, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	downsampleStep, err := parseOptionalDuration(config.DownsampleStep, DefaultDownsampleStep)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := OpenStore(config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := Recorder{
+		store:           store,
+		retention:       retention,
+		downsampleAfter: downsampleAfter,
+		downsampleStep:  downsampleStep,
+		written:         make(map[string]time.Time),
+	}
+
+	return &recorder, nil
+}
+
+// Returns records of registers updated since the last call
+func (this *Recorder) newRecords(state collector.PollState) []Record {
+	records := []Record{}
+
+	for exportId, v := range state {
+		if v.LastValue == nil || !v.LastUpdate.After(this.written[exportId]) {
+			continue
+		}
+
+		units := v.Register.Units
+		if v.LastValue.Units != nil {
+			units = *v.LastValue.Units
+		}
+
+		records = append(records, Record{
+			Id:     exportId,
+			Sample: Sample{Time: v.LastUpdate, Value: v.LastValue.ToStringRaw(), Units: units},
+		})
+
+		this.written[exportId] = v.LastUpdate
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Time.Equal(records[j].Time) {
+			return records[i].Id < records[j].Id
+		}
+		return records[i].Time.Before(records[j].Time)
+	})
+
+	return records
+}
+
+func (this *Recorder) maintain() {
+	err := this.store.Maintain(time.Now(), this.retention, this.downsampleAfter, this.downsampleStep)
+	if err != nil {
+		log.PrError("history: maintenance failed: %s\n", err)
+	}
+}
+
+func (this *Recorder) eventLoop(sub *collector.Subscription) {
+	ticker := time.NewTicker(maintenanceInterval)
+
+	for {
+		select {
+		case state := <-sub.State:
+			if err := this.store.Append(this.newRecords(state)); err != nil {
+				log.PrError("history: failed to record values: %s\n", err)
+			}
+		case <-ticker.C:
+			this.maintain()
+		case <-sub.Conn:
+		case <-sub.Flow:
+		}
+	}
+}
+
+func (this *Recorder) Start(sub *collector.Subscription) error {
+	log.PrInfo("history: recording values to %s\n", this.store.path)
+
+	this.maintain()
+
+	go this.eventLoop(sub)
+
+	return nil
+}
@@ -0,0 +1,473 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dayLayout         = "2006-01-02"
+	rawSuffix         = ".csv"
+	downsampledSuffix = ".downsampled.csv"
+)
+
+type Sample struct {
+	Time  time.Time
+	Value string
+	Units string
+}
+
+type Record struct {
+	Id string
+	Sample
+}
+
+// Register values store. Values are appended to daily CSV files (UTC days) with
+// time,id,value,units records, days older than downsampling age are replaced with
+// downsampled files.
+type Store struct {
+	path string
+	mtx  sync.Mutex
+	// currently appended file
+	file    *os.File
+	fileDay string
+}
+
+// Parses duration with optional day suffix, e.g. "7d"
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("invalid duration %q", s))
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Store{path: path}, nil
+}
+
+func (this *Store) Close() error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+
+	return this.closeFile()
+}
+
+func (this *Store) closeFile() error {
+	if this.file == nil {
+		return nil
+	}
+
+	err := this.file.Close()
+	this.file = nil
+	this.fileDay = ""
+
+	return err
+}
+
+func (this *Store) dayPath(day string, suffix string) string {
+	return filepath.Join(this.path, day+suffix)
+}
+
+func (this *Store) Append(records []Record) error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+
+	for len(records) > 0 {
+		day := records[0].Time.UTC().Format(dayLayout)
+
+		n := 1
+		for n < len(records) && records[n].Time.UTC().Format(dayLayout) == day {
+			n++
+		}
+
+		if this.fileDay != day {
+			if err := this.closeFile(); err != nil {
+				return err
+			}
+
+			file, err := os.OpenFile(this.dayPath(day, rawSuffix), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+			if err != nil {
+				return err
+			}
+
+			if err := terminateLastLine(file); err != nil {
+				file.Close()
+				return err
+			}
+
+			this.file = file
+			this.fileDay = day
+		}
+
+		// records are written with a single call, readers skip the last line until it's terminated
+		if _, err := this.file.Write(encodeRecords(records[:n])); err != nil {
+			return err
+		}
+
+		records = records[n:]
+	}
+
+	return nil
+}
+
+// Terminates a line left partially written (e.g. by a crash), so that new records are not
+// merged into it
+func terminateLastLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+
+	if last[0] == '\n' {
+		return nil
+	}
+
+	_, err = file.Write([]byte{'\n'})
+	return err
+}
+
+// Encodes records as CSV lines, line breaks in values are replaced with spaces
+func encodeRecords(records []Record) []byte {
+	builder := strings.Builder{}
+	writer := csv.NewWriter(&builder)
+
+	singleLine := strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+	for _, r := range records {
+		writer.Write([]string{
+			r.Time.UTC().Format(time.RFC3339Nano),
+			singleLine.Replace(r.Id),
+			singleLine.Replace(r.Value),
+			singleLine.Replace(r.Units),
+		})
+	}
+
+	writer.Flush()
+
+	return []byte(builder.String())
+}
+
+// Reads records of a file. The file may be appended by the recorder while it is read
+// (e.g. by openess history), so the last line is ignored if it's not terminated yet.
+// Malformed lines (e.g. partially written before a crash) are skipped.
+func readRecords(path string, filter func(r *Record) bool) ([]Record, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	records := []Record{}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// records are single lines, so a torn quoted field doesn't swallow the next ones
+		fields, err := csv.NewReader(bytes.NewReader(line)).Read()
+		if err != nil || len(fields) != 4 {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			continue
+		}
+
+		r := Record{Id: fields[1], Sample: Sample{Time: t, Value: fields[2], Units: fields[3]}}
+		if filter(&r) {
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
+}
+
+// Returns days of stored files, sorted
+func (this *Store) days() ([]string, error) {
+	entries, err := os.ReadDir(this.path)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+
+	for _, e := range entries {
+		day, ok := strings.CutSuffix(e.Name(), downsampledSuffix)
+		if !ok {
+			day, ok = strings.CutSuffix(e.Name(), rawSuffix)
+		}
+
+		if _, err := time.Parse(dayLayout, day); ok && err == nil {
+			found[day] = true
+		}
+	}
+
+	days := []string{}
+	for day := range found {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	return days, nil
+}
+
+func (this *Store) readDay(day string, filter func(r *Record) bool) ([]Record, error) {
+	records, err := readRecords(this.dayPath(day, downsampledSuffix), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := readRecords(this.dayPath(day, rawSuffix), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(records, raw...), nil
+}
+
+// Returns values of the register in [since, until] range. Values are averaged over
+// step intervals if step is not zero.
+func (this *Store) Query(id string, since time.Time, until time.Time, step time.Duration) ([]Sample, error) {
+	days, err := this.days()
+	if err != nil {
+		return nil, err
+	}
+
+	samples := []Sample{}
+
+	for _, day := range days {
+		start, _ := time.Parse(dayLayout, day)
+		if !start.Add(24*time.Hour).After(since) || start.After(until) {
+			continue
+		}
+
+		records, err := this.readDay(day, func(r *Record) bool {
+			return r.Id == id && !r.Time.Before(since) && !r.Time.After(until)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range records {
+			samples = append(samples, r.Sample)
+		}
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+
+	if step > 0 {
+		samples = downsample(samples, step)
+	}
+
+	return samples, nil
+}
+
+// Returns ids of registers stored in the last day file
+func (this *Store) Ids() ([]string, error) {
+	days, err := this.days()
+	if err != nil || len(days) == 0 {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+
+	_, err = this.readDay(days[len(days)-1], func(r *Record) bool {
+		found[r.Id] = true
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func decimals(value string) int {
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		return len(value) - i - 1
+	}
+	return 0
+}
+
+// Merges time sorted samples into step intervals starting at multiples of step.
+// Numeric values are averaged, the last value of the interval is taken otherwise.
+func downsample(samples []Sample, step time.Duration) []Sample {
+	result := []Sample{}
+
+	for i := 0; i < len(samples); {
+		start := samples[i].Time.Truncate(step)
+
+		j := i
+		for j < len(samples) && samples[j].Time.Truncate(step).Equal(start) {
+			j++
+		}
+
+		merged := samples[j-1]
+		merged.Time = start
+
+		sum := 0.0
+		digits := 0
+		numeric := true
+
+		for _, s := range samples[i:j] {
+			num, err := strconv.ParseFloat(s.Value, 64)
+			if err != nil || math.IsNaN(num) || math.IsInf(num, 0) {
+				numeric = false
+				break
+			}
+
+			sum += num
+			digits = max(digits, decimals(s.Value))
+		}
+
+		if numeric {
+			merged.Value = strconv.FormatFloat(sum/float64(j-i), 'f', digits, 64)
+		}
+
+		result = append(result, merged)
+		i = j
+	}
+
+	return result
+}
+
+// Removes days older than retention (if not zero) and downsamples days older than
+// downsampleAfter (if not zero)
+func (this *Store) Maintain(now time.Time, retention time.Duration, downsampleAfter time.Duration, step time.Duration) error {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+
+	days, err := this.days()
+	if err != nil {
+		return err
+	}
+
+	for _, day := range days {
+		start, _ := time.Parse(dayLayout, day)
+		end := start.Add(24 * time.Hour)
+
+		expired := retention > 0 && !end.After(now.Add(-retention))
+		stale := downsampleAfter > 0 && !end.After(now.Add(-downsampleAfter))
+
+		if day == this.fileDay && (expired || stale) {
+			if err := this.closeFile(); err != nil {
+				return err
+			}
+		}
+
+		if expired {
+			for _, suffix := range []string{rawSuffix, downsampledSuffix} {
+				if err := os.Remove(this.dayPath(day, suffix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+			continue
+		}
+
+		if stale {
+			if err := this.downsampleDay(day, step); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (this *Store) downsampleDay(day string, step time.Duration) error {
+	rawPath := this.dayPath(day, rawSuffix)
+
+	if _, err := os.Stat(rawPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	path := this.dayPath(day, downsampledSuffix)
+
+	// values written after the day was downsampled are merged with the downsampled ones
+	records, err := readRecords(path, func(r *Record) bool { return true })
+	if err != nil {
+		return err
+	}
+
+	raw, err := readRecords(rawPath, func(r *Record) bool { return true })
+	if err != nil {
+		return err
+	}
+
+	records = append(records, raw...)
+
+	byId := map[string][]Sample{}
+	for _, r := range records {
+		byId[r.Id] = append(byId[r.Id], r.Sample)
+	}
+
+	result := []Record{}
+	for id, samples := range byId {
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+
+		for _, s := range downsample(samples, step) {
+			result = append(result, Record{Id: id, Sample: s})
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Time.Equal(result[j].Time) {
+			return result[i].Id < result[j].Id
+		}
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	tmpPath := path + ".tmp"
+
+	if err := os.WriteFile(tmpPath, encodeRecords(result), 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	return os.Remove(rawPath)
+}
@@ -0,0 +1,181 @@
+package history
+
+import (
+	"fmt"
+	"openess/internal/collector"
+	"openess/internal/commands"
+	"openess/internal/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func formatSamples(samples []Sample) string {
+	result := ""
+	for _, s := range samples {
+		result += fmt.Sprintf("%s=%s%s;", s.Time.UTC().Format("01-02 15:04:05"), s.Value, s.Units)
+	}
+	return result
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+	defer store.Close()
+
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	records := []Record{}
+	for i := 0; i < 4; i++ {
+		at := day.Add(23*time.Hour + 58*time.Minute + time.Duration(i)*time.Minute)
+		records = append(records,
+			Record{Id: "voltage", Sample: Sample{Time: at, Value: fmt.Sprintf("%d.5", 230+i), Units: "V"}},
+			Record{Id: "mode", Sample: Sample{Time: at, Value: fmt.Sprintf("Mode, %d", i)}})
+	}
+
+	if err := store.Append(records); err != nil {
+		t.Fatalf("failed to append: %s", err)
+	}
+
+	// partially written record is skipped
+	file, _ := os.OpenFile(filepath.Join(dir, "2024-01-11.csv"), os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("2024-01-11T00:03:00Z,volt")
+	file.Close()
+
+	samples, err := store.Query("voltage", day, day.Add(48*time.Hour), 0)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+
+	expected := "01-10 23:58:00=230.5V;01-10 23:59:00=231.5V;01-11 00:00:00=232.5V;01-11 00:01:00=233.5V;"
+	if formatSamples(samples) != expected {
+		t.Fatalf("unexpected samples %s", formatSamples(samples))
+	}
+
+	samples, _ = store.Query("voltage", day.Add(23*time.Hour+59*time.Minute), day.Add(24*time.Hour), 0)
+	if formatSamples(samples) != "01-10 23:59:00=231.5V;01-11 00:00:00=232.5V;" {
+		t.Fatalf("unexpected samples in range %s", formatSamples(samples))
+	}
+
+	samples, _ = store.Query("voltage", day, day.Add(48*time.Hour), 2*time.Minute)
+	if formatSamples(samples) != "01-10 23:58:00=231.0V;01-11 00:00:00=233.0V;" {
+		t.Fatalf("unexpected averaged samples %s", formatSamples(samples))
+	}
+
+	samples, _ = store.Query("mode", day, day.Add(48*time.Hour), 2*time.Minute)
+	if formatSamples(samples) != "01-10 23:58:00=Mode, 1;01-11 00:00:00=Mode, 3;" {
+		t.Fatalf("unexpected downsampled enum samples %s", formatSamples(samples))
+	}
+
+	ids, _ := store.Ids()
+	if fmt.Sprint(ids) != "[mode voltage]" {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+
+	// the first day is downsampled, the second one is kept as is
+	if err := store.Maintain(day.Add(48*time.Hour), 0, 24*time.Hour, 2*time.Minute); err != nil {
+		t.Fatalf("failed to maintain: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "2024-01-10.csv")); err == nil {
+		t.Fatalf("raw file of downsampled day is not removed")
+	}
+
+	samples, _ = store.Query("voltage", day, day.Add(48*time.Hour), 0)
+	if formatSamples(samples) != "01-10 23:58:00=231.0V;01-11 00:00:00=232.5V;01-11 00:01:00=233.5V;" {
+		t.Fatalf("unexpected samples after downsampling %s", formatSamples(samples))
+	}
+
+	// late values are merged with downsampled ones
+	store.Append([]Record{{Id: "voltage", Sample: Sample{Time: day.Add(time.Hour), Value: "220.0", Units: "V"}}})
+	store.Maintain(day.Add(48*time.Hour), 0, 24*time.Hour, 2*time.Minute)
+
+	samples, _ = store.Query("voltage", day, day.Add(24*time.Hour-time.Nanosecond), 0)
+	if formatSamples(samples) != "01-10 01:00:00=220.0V;01-10 23:58:00=231.0V;" {
+		t.Fatalf("unexpected samples after merging %s", formatSamples(samples))
+	}
+
+	if err := store.Maintain(day.Add(72*time.Hour), 48*time.Hour, 0, 0); err != nil {
+		t.Fatalf("failed to maintain: %s", err)
+	}
+
+	samples, _ = store.Query("voltage", day, day.Add(48*time.Hour), 0)
+	if formatSamples(samples) != "01-11 00:00:00=232.5V;01-11 00:01:00=233.5V;" {
+		t.Fatalf("unexpected samples after retention %s", formatSamples(samples))
+	}
+}
+
+func TestTornLines(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	store, _ := OpenStore(dir)
+	store.Append([]Record{{Id: "mode", Sample: Sample{Time: day, Value: "Line"}}})
+	store.Close()
+
+	// value being written by the recorder (or left by a crash) with an open quote
+	file, _ := os.OpenFile(filepath.Join(dir, "2024-01-10.csv"), os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString(`2024-01-10T00:01:00Z,mode,"Mode, `)
+	file.Close()
+
+	store, _ = OpenStore(dir)
+	defer store.Close()
+
+	samples, err := store.Query("mode", day, day.Add(time.Hour), 0)
+	if err != nil || formatSamples(samples) != "01-10 00:00:00=Line;" {
+		t.Fatalf("unexpected samples %s (%v)", formatSamples(samples), err)
+	}
+
+	// the torn line is terminated, so records appended after it are not lost
+	store.Append([]Record{{Id: "mode", Sample: Sample{Time: day.Add(2 * time.Minute), Value: "Battery\nfirst"}}})
+
+	samples, err = store.Query("mode", day, day.Add(time.Hour), 0)
+	if err != nil || formatSamples(samples) != "01-10 00:00:00=Line;01-10 00:02:00=Battery first;" {
+		t.Fatalf("unexpected samples after append %s (%v)", formatSamples(samples), err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := NewRecorder(Config{Path: dir, Retention: "week"}); err == nil {
+		t.Fatalf("expected invalid retention error")
+	}
+
+	recorder, err := NewRecorder(Config{Path: dir, Retention: "30d"})
+	if err != nil {
+		t.Fatalf("failed to create recorder: %s", err)
+	}
+
+	if recorder.retention != 30*24*time.Hour || recorder.downsampleStep != DefaultDownsampleStep {
+		t.Fatalf("unexpected durations %s %s", recorder.retention, recorder.downsampleStep)
+	}
+
+	voltage := float32(230.1)
+	digits := 1
+	reg := protocol.Register{Units: "V"}
+	at := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	state := collector.PollState{
+		"voltage": {
+			Register:   &reg,
+			LastValue:  &commands.RegValue{Type: commands.RegTypeFloat, ValueFloat: &voltage, Digits: &digits},
+			LastUpdate: at,
+		},
+		"unread": {Register: &reg},
+	}
+
+	records := recorder.newRecords(state)
+	if len(records) != 1 || records[0].Id != "voltage" || records[0].Value != "230.1" || records[0].Units != "V" {
+		t.Fatalf("unexpected records %v", records)
+	}
+
+	if records := recorder.newRecords(state); len(records) != 0 {
+		t.Fatalf("values not updated since the last call should be skipped: %v", records)
+	}
+}
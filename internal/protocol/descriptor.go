@@ -1,13 +1,16 @@
 package protocol
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type Segment struct {
@@ -28,30 +31,87 @@ type AddressOffset struct {
 	OffsetBase    int
 }
 
+const (
+	OffsetTypeNone   = 0
+	OffsetTypeModbus = 1
+)
+
+// Translates a register address from the descriptor into the address sent over the wire.
+// With offsetType 1 descriptor addresses are Modbus style references (e.g. 30001 or 40001 with
+// offsetBase 10000), so the wire address is the remainder after offsetBase minus offsetAddress.
+func (offset AddressOffset) WireAddress(addr uint16) (uint16, error) {
+	var wire int64
+
+	switch offset.OffsetType {
+	case OffsetTypeNone:
+		wire = int64(addr)
+	case OffsetTypeModbus:
+		if int(addr)/offset.OffsetBase > 9 {
+			return 0, errors.New(fmt.Sprintf("address %d does not match offset base %d", addr, offset.OffsetBase))
+		}
+		wire = int64(int(addr)%offset.OffsetBase) - int64(offset.OffsetAddress)
+	default:
+		return 0, errors.New(fmt.Sprintf("unsupported addressing mode (offsetType = %d)", offset.OffsetType))
+	}
+
+	if wire < 0 || wire > math.MaxUint16 {
+		return 0, errors.New(fmt.Sprintf("address %d is out of range", addr))
+	}
+
+	return uint16(wire), nil
+}
+
+// Named entry of OtherCodes section. Object entries are enumerations shared between registers,
+// array entries either describe bitfields referenced by subModels (every item is a bit range
+// with its address being the offset of the first bit) or step enumerations which are not used.
 type ExternEnum struct {
-	Variants map[int]string
+	Variants     map[int]string
+	Translations map[string]map[int]string
+	Fields       []Register
 }
 
 func (this *ExternEnum) UnmarshalJSON(data []byte) error {
-	type baseVariants struct {
-		Base map[string]string
-	}
-
 	variants := make(map[int]string)
 
 	if data[0] == '{' {
-		var inner baseVariants
-		err := json.Unmarshal(data, &inner)
+		var langs map[string]map[string]string
+		err := json.Unmarshal(data, &langs)
 		if err != nil {
 			return err
 		}
-		for k, v := range inner.Base {
-			num, err := strconv.Atoi(k)
-			if err != nil {
-				return fmt.Errorf("failed to parse enum key as int: %v", err)
+		for lang, inner := range langs {
+			langVariants := make(map[int]string)
+			for k, v := range inner {
+				num, err := strconv.Atoi(k)
+				if err != nil {
+					return fmt.Errorf("failed to parse enum key as int: %v", err)
+				}
+
+				langVariants[num] = v
+			}
+
+			if strings.EqualFold(lang, "base") {
+				variants = langVariants
+				continue
+			}
+
+			if this.Translations == nil {
+				this.Translations = make(map[string]map[int]string)
 			}
+			this.Translations[lang] = langVariants
+		}
+	}
 
-			variants[num] = v
+	if data[0] == '[' {
+		var fields []Register
+		err := json.Unmarshal(data, &fields)
+		if err != nil {
+			return fmt.Errorf("failed to parse bitfield: %v", err)
+		}
+		for _, f := range fields {
+			if len(f.Title) != 0 {
+				this.Fields = append(this.Fields, f)
+			}
 		}
 	}
 
@@ -60,6 +120,16 @@ func (this *ExternEnum) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Returns enum variant label in the language falling back to base one
+func (this *ExternEnum) Label(variant int, lang string) (string, bool) {
+	if label, ok := this.Translations[lang][variant]; ok && label != "" {
+		return label, true
+	}
+
+	label, ok := this.Variants[variant]
+	return label, ok
+}
+
 type DevAddr byte
 
 func (addr *DevAddr) UnmarshalJSON(data []byte) error {
@@ -72,6 +142,19 @@ func (addr *DevAddr) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Byte order of a register, stored both as a number and a string (e.g. "2" in 0975)
+type ByteSort int
+
+func (v *ByteSort) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	val, err := strconv.Atoi(strings.Trim(s, "\""))
+	if err != nil {
+		return err
+	}
+	*v = ByteSort(val)
+	return nil
+}
+
 type EnumVariant int
 
 func (v *EnumVariant) UnmarshalJSON(data []byte) error {
@@ -84,10 +167,29 @@ func (v *EnumVariant) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Continuous range of registers read with a single request
+type RegisterBlock struct {
+	FunNumber    byte `json:",string"`
+	StartAddress uint16
+	Length       uint16
+}
+
+// Energy flow diagram description. Flows are energy lines between inverter and
+// pv/battery/grid/load/generator (non-zero value means the line is active), infos are
+// registers shown next to the diagram nodes.
+type FlowInfo struct {
+	Flows      map[string][]RegisterBlock
+	Infos      map[string][]RegisterBlock
+	NoteValues map[string][]RegisterBlock
+	Controls   []RegisterBlock
+}
+
 type Configuration struct {
 	DevAddrs         []DevAddr
 	SystemSettingVC  []ConfigurationGroup
 	SystemInfoVC     []ConfigurationGroup
+	FlowInfoVC       *FlowInfo
+	LoopCMDs         []RegisterBlock
 	WriteMoreFunCode byte
 	WriteOneFunCode  byte
 	AddressOffset    AddressOffset
@@ -98,21 +200,70 @@ const (
 	ByteSortBigEndian    = 0
 )
 
+const (
+	ValueTypeSigned   = 0
+	ValueTypeUnsigned = 1
+	ValueTypeString   = 2
+	ValueTypeBCD      = 3
+)
+
+const (
+	BcdTypePacked = 0 // every byte is a two-digit BCD number
+	BcdTypeBinary = 1 // every byte is a plain binary number
+	BcdTypeNumber = 2 // the whole register is a single BCD number
+)
+
 type Enumeration struct {
-	Variants map[EnumVariant]*string
-	External *string
+	Variants     map[EnumVariant]*string
+	Translations map[string]map[EnumVariant]*string
+	External     *string
 }
 
-func (v *Enumeration) UnmarshalJSON(data []byte) error {
-	type baseVariants struct {
-		Base map[EnumVariant]*string
+func parseEnumVariants(data []byte) (map[EnumVariant]*string, error) {
+	var variants map[EnumVariant]*string
+
+	err := json.Unmarshal(data, &variants)
+	if err == nil {
+		return variants, nil
 	}
 
-	var variants baseVariants
+	// some descriptors list variants as an array indexed by value
+	var list []*string
 
-	err := json.Unmarshal(data, &variants)
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	variants = make(map[EnumVariant]*string)
+	for i, s := range list {
+		variants[EnumVariant(i)] = s
+	}
+
+	return variants, nil
+}
+
+func (v *Enumeration) UnmarshalJSON(data []byte) error {
+	var langs map[string]json.RawMessage
+
+	err := json.Unmarshal(data, &langs)
 	if err == nil {
-		v.Variants = variants.Base
+		for lang, raw := range langs {
+			variants, err := parseEnumVariants(raw)
+			if err != nil {
+				return err
+			}
+
+			if strings.EqualFold(lang, "base") {
+				v.Variants = variants
+				continue
+			}
+
+			if v.Translations == nil {
+				v.Translations = make(map[string]map[EnumVariant]*string)
+			}
+			v.Translations[lang] = variants
+		}
 		return nil
 	}
 
@@ -127,23 +278,163 @@ func (v *Enumeration) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Returns enum variant label in the language falling back to base one
+func (v *Enumeration) Label(variant EnumVariant, lang string) (*string, bool) {
+	if label, ok := v.Translations[lang][variant]; ok && label != nil && *label != "" {
+		return label, true
+	}
+
+	label, ok := v.Variants[variant]
+	return label, ok
+}
+
+// Returns localized labels of the register enumeration (either own or external) by variant,
+// nil if the register is not an enumeration
+func (desc *Descriptor) EnumLabels(reg *Register) map[int]string {
+	if reg.EnumerationStrings == nil {
+		return nil
+	}
+
+	labels := make(map[int]string)
+
+	if reg.EnumerationStrings.External != nil {
+		enum, ok := desc.OtherCodes[*reg.EnumerationStrings.External]
+		if !ok {
+			return nil
+		}
+
+		for variant := range enum.Variants {
+			labels[variant], _ = enum.Label(variant, desc.Language)
+		}
+
+		return labels
+	}
+
+	for variant := range reg.EnumerationStrings.Variants {
+		if label, ok := reg.EnumerationStrings.Label(variant, desc.Language); ok && label != nil {
+			labels[int(variant)] = *label
+		}
+	}
+
+	return labels
+}
+
+type RangeVariant struct {
+	Min   float64
+	Max   float64
+	EqMin bool
+	EqMax bool
+	Value string
+}
+
+func (v RangeVariant) Contains(value float64) bool {
+	aboveMin := value > v.Min || (v.EqMin && value == v.Min)
+	belowMax := value < v.Max || (v.EqMax && value == v.Max)
+	return aboveMin && belowMax
+}
+
+type RangeEnumeration struct {
+	Variants []RangeVariant
+}
+
+func (e *RangeEnumeration) UnmarshalJSON(data []byte) error {
+	type baseVariants struct {
+		Base []RangeVariant
+	}
+
+	var variants baseVariants
+
+	err := json.Unmarshal(data, &variants)
+	if err != nil {
+		return err
+	}
+
+	e.Variants = variants.Base
+
+	return nil
+}
+
+// Returns a label of the first range containing the (scaled) value
+func (e RangeEnumeration) Find(value float64) *string {
+	for _, v := range e.Variants {
+		if v.Contains(value) {
+			label := v.Value
+			return &label
+		}
+	}
+
+	return nil
+}
+
 type Register struct {
 	Address            uint16
-	ByteSort           int
+	ByteSort           ByteSort
 	Length             *int
 	Title              map[string]string
 	EnumerationStrings *Enumeration
 	ValueType          int
 	Units              string
 	Scale              float32
+	Offset             float32
+	Digits             *int
+	FunNumber          *byte `json:",string"`
+	BcdType            *int
+	SpaceMark          string
+	SubModels          *string
+
+	RangeEnumerationStrings *RangeEnumeration
+
+	// address doesn't fit 16 bits, such registers are dropped on load
+	invalidAddress bool
+}
+
+// Registers with addresses outside of 16 bit range (e.g. 420102 in 0911) can't be
+// accessed, they are marked instead of failing the whole descriptor.
+func (reg *Register) UnmarshalJSON(data []byte) error {
+	type plainRegister Register
+
+	var parsed struct {
+		plainRegister
+		Address int64
+	}
+
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+
+	*reg = Register(parsed.plainRegister)
+
+	if parsed.Address < 0 || parsed.Address > math.MaxUint16 {
+		reg.invalidAddress = true
+	} else {
+		reg.Address = uint16(parsed.Address)
+	}
+
+	return nil
+}
+
+// Returns register title in the language falling back to base one
+func (reg *Register) Name(lang string) string {
+	if name, ok := reg.Title[lang]; ok && name != "" {
+		return name
+	}
+	return reg.Title["base"]
 }
 
 type Descriptor struct {
 	Root          []Register
 	Configuration Configuration
 	OtherCodes    map[string]ExternEnum
+
+	// Language of register titles and enum labels (e.g. zh_cn), base titles are used if empty
+	Language string `json:"-"`
+
+	index     *descriptorIndex
+	indexOnce sync.Once
 }
 
+var byteOrderMark = []byte{0xef, 0xbb, 0xbf}
+
 func LoadProtocolDescriptor(path string) (*Descriptor, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -156,6 +447,9 @@ func LoadProtocolDescriptor(path string) (*Descriptor, error) {
 		return nil, err
 	}
 
+	// descriptors pulled from some APKs are saved with UTF-8 BOM
+	data = bytes.TrimPrefix(data, byteOrderMark)
+
 	var result Descriptor
 
 	err = json.Unmarshal([]byte(data), &result)
@@ -163,77 +457,312 @@ func LoadProtocolDescriptor(path string) (*Descriptor, error) {
 		return nil, err
 	}
 
-	if result.Configuration.AddressOffset.OffsetType != 0 {
-		return nil, errors.New("unsupported addressing mode (offsetType != 0)")
+	if err := result.prepare(); err != nil {
+		return nil, err
 	}
 
 	return &result, nil
 }
 
-func (desc Descriptor) FindRegisterByAddr(addr uint16) *Register {
-	var reg *Register
+// Checks addressing mode, drops registers which can't be accessed and builds lookup index
+func (result *Descriptor) prepare() error {
+	offset := result.Configuration.AddressOffset
 
-	for _, r := range desc.Root {
-		if r.Address == addr {
-			return &r
+	switch offset.OffsetType {
+	case OffsetTypeNone:
+	case OffsetTypeModbus:
+		if offset.OffsetBase <= 0 {
+			return errors.New(fmt.Sprintf("invalid offset base %d", offset.OffsetBase))
 		}
+	default:
+		return errors.New(fmt.Sprintf("unsupported addressing mode (offsetType = %d)", offset.OffsetType))
 	}
 
-	return reg
+	// registers with addresses out of range are reported by the linter
+	root := []Register{}
+	for _, reg := range result.Root {
+		if !reg.invalidAddress {
+			root = append(root, reg)
+		}
+	}
+	result.Root = root
+
+	result.getIndex()
+
+	return nil
 }
 
-func (desc Descriptor) FindRegister(name string) (*Segment, *Register) {
-	var reg *Register
+func (desc *Descriptor) WireAddress(addr uint16) (uint16, error) {
+	return desc.Configuration.AddressOffset.WireAddress(addr)
+}
 
-	for _, r := range desc.Root {
-		regName, ok := r.Title["base"]
+// Address of a register along with its own function code, 0 if it's read with the
+// function code of its segment
+type registerKey struct {
+	funNumber byte
+	addr      uint16
+}
 
-		if ok && regName == name {
-			reg = &r
-			break
+type descriptorIndex struct {
+	registersByAddr map[uint16]*Register
+	registersByKey  map[registerKey]*Register
+	registersByName map[string]*Register
+	segmentsByAddr  map[uint16][]SegmentRef
+	groupsByName    map[string]*ConfigurationGroup
+}
+
+type SegmentRef struct {
+	Segment *Segment
+	Group   *ConfigurationGroup
+}
+
+// Builds address and name lookup tables
+func (desc *Descriptor) buildIndex() {
+	index := descriptorIndex{
+		registersByAddr: make(map[uint16]*Register),
+		registersByKey:  make(map[registerKey]*Register),
+		registersByName: make(map[string]*Register),
+		segmentsByAddr:  make(map[uint16][]SegmentRef),
+		groupsByName:    make(map[string]*ConfigurationGroup),
+	}
+
+	// on duplicates the first register wins, same as with linear lookup
+	for i := range desc.Root {
+		r := &desc.Root[i]
+
+		if _, ok := index.registersByAddr[r.Address]; !ok {
+			index.registersByAddr[r.Address] = r
+		}
+
+		key := registerKey{addr: r.Address}
+		if r.FunNumber != nil {
+			key.funNumber = *r.FunNumber
+		}
+		if _, ok := index.registersByKey[key]; !ok {
+			index.registersByKey[key] = r
+		}
+
+		if name, ok := r.Title["base"]; ok {
+			if _, ok := index.registersByName[name]; !ok {
+				index.registersByName[name] = r
+			}
 		}
 	}
 
-	if reg == nil {
-		return nil, nil
+	// registers can also be looked up by their translated titles unless these clash with base ones
+	for i := range desc.Root {
+		r := &desc.Root[i]
+
+		for lang, name := range r.Title {
+			if lang == "base" || name == "" {
+				continue
+			}
+			if _, ok := index.registersByName[name]; !ok {
+				index.registersByName[name] = r
+			}
+		}
 	}
 
-	var seg *Segment
+	groups := [][]ConfigurationGroup{desc.Configuration.SystemInfoVC, desc.Configuration.SystemSettingVC}
+
+	for _, gs := range groups {
+		for i := range gs {
+			g := &gs[i]
+
+			if _, ok := index.groupsByName[g.Title["base"]]; !ok {
+				index.groupsByName[g.Title["base"]] = g
+			}
 
-	for _, g := range desc.Configuration.SystemInfoVC {
-		for _, s := range g.Segments {
-			if s.StartAddress >= reg.Address && (s.StartAddress+s.Length) >= reg.Address {
-				seg = &s
-				goto exit
+			for j := range g.Segments {
+				s := &g.Segments[j]
+				for i := uint16(0); i < s.Length; i++ {
+					addr := s.StartAddress + i
+					index.segmentsByAddr[addr] = append(index.segmentsByAddr[addr], SegmentRef{Segment: s, Group: g})
+				}
 			}
 		}
 	}
 
-	for _, g := range desc.Configuration.SystemSettingVC {
-		for _, s := range g.Segments {
-			if s.StartAddress >= reg.Address && (s.StartAddress+s.Length) >= reg.Address {
-				seg = &s
-				goto exit
+	for _, gs := range groups {
+		for i := range gs {
+			for _, name := range gs[i].Title {
+				if _, ok := index.groupsByName[name]; !ok && name != "" {
+					index.groupsByName[name] = &gs[i]
+				}
 			}
 		}
 	}
 
-exit:
-	return seg, reg
+	desc.index = &index
 }
 
-func (desc Descriptor) FindGroup(name string) []Segment {
-	for _, g := range desc.Configuration.SystemInfoVC {
-		if g.Title["base"] == name {
-			return g.Segments
+// Returns lookup tables. They are built by LoadProtocolDescriptor, descriptors constructed
+// by hand are indexed on first lookup, which is safe to do from several goroutines.
+func (desc *Descriptor) getIndex() *descriptorIndex {
+	desc.indexOnce.Do(desc.buildIndex)
+	return desc.index
+}
+
+func (desc *Descriptor) FindRegisterByAddr(addr uint16) *Register {
+	return desc.getIndex().registersByAddr[addr]
+}
+
+// Finds a register read with the function code at the address. Registers with their own
+// funNumber (e.g. input and holding registers sharing addresses in 0975) are preferred,
+// registers without it are returned otherwise.
+func (desc *Descriptor) FindRegisterByAddrFun(funNumber byte, addr uint16) *Register {
+	index := desc.getIndex()
+
+	if reg, ok := index.registersByKey[registerKey{funNumber: funNumber, addr: addr}]; ok {
+		return reg
+	}
+
+	return index.registersByKey[registerKey{addr: addr}]
+}
+
+// Returns all segments containing the address
+func (desc *Descriptor) FindSegments(addr uint16) []SegmentRef {
+	return desc.getIndex().segmentsByAddr[addr]
+}
+
+// Returns all segments containing the whole register
+func (desc *Descriptor) LocateRegister(reg *Register) []SegmentRef {
+	length := uint32(1)
+	if reg.Length != nil {
+		length = uint32(*reg.Length)
+	}
+
+	refs := []SegmentRef{}
+
+	for _, ref := range desc.FindSegments(reg.Address) {
+		if uint32(reg.Address)+length <= uint32(ref.Segment.StartAddress)+uint32(ref.Segment.Length) {
+			refs = append(refs, ref)
+		}
+	}
+
+	return refs
+}
+
+// Returns all registers fully contained in the block, ordered by address
+func (desc *Descriptor) BlockRegisters(block RegisterBlock) []*Register {
+	regs := []*Register{}
+	end := uint32(block.StartAddress) + uint32(block.Length)
+
+	for addr := uint32(block.StartAddress); addr < end; {
+		reg := desc.FindRegisterByAddrFun(block.FunNumber, uint16(addr))
+		if reg == nil {
+			addr += 1
+			continue
+		}
+
+		length := uint32(1)
+		if reg.Length != nil {
+			length = uint32(*reg.Length)
+		}
+
+		if addr+length > end {
+			break
+		}
+
+		regs = append(regs, reg)
+		addr += length
+	}
+
+	return regs
+}
+
+// Returns the first LoopCMDs block containing the whole register
+func (desc *Descriptor) FindLoopBlock(reg *Register) *RegisterBlock {
+	length := uint32(1)
+	if reg.Length != nil {
+		length = uint32(*reg.Length)
+	}
+
+	for i := range desc.Configuration.LoopCMDs {
+		block := &desc.Configuration.LoopCMDs[i]
+		start := uint32(block.StartAddress)
+
+		if uint32(reg.Address) >= start && uint32(reg.Address)+length <= start+uint32(block.Length) {
+			return block
+		}
+	}
+
+	return nil
+}
+
+type RegisterLocation struct {
+	Register *Register
+	Segment  *Segment
+	Group    *ConfigurationGroup
+}
+
+// Finds a register by name along with the segment it should be read with.
+// Registers outside of any segment are resolved if they specify their own funNumber
+// (in which case Segment is nil) or are in a LoopCMDs block (in which case Segment is
+// the block and Group is nil), registers in several segments are resolved only if
+// all of these segments use the same function code.
+func (desc *Descriptor) ResolveRegister(name string) (*RegisterLocation, error) {
+	reg := desc.getIndex().registersByName[name]
+
+	if reg == nil {
+		return nil, errors.New(fmt.Sprintf("register %q not found in descriptor", name))
+	}
+
+	loc := RegisterLocation{Register: reg}
+
+	refs := desc.LocateRegister(reg)
+
+	if len(refs) == 0 {
+		if reg.FunNumber != nil {
+			return &loc, nil
 		}
+
+		// identity registers (e.g. model and serial number in 1209) are read only by LoopCMDs
+		block := desc.FindLoopBlock(reg)
+		if block == nil {
+			return nil, errors.New(fmt.Sprintf("register %q (%d) is not in any segment", name, reg.Address))
+		}
+
+		loc.Segment = &Segment{StartAddress: block.StartAddress, Length: block.Length, FunNumber: block.FunNumber}
+		return &loc, nil
 	}
 
-	for _, g := range desc.Configuration.SystemSettingVC {
-		if g.Title["base"] == name {
-			return g.Segments
+	for _, ref := range refs[1:] {
+		if ref.Segment.FunNumber != refs[0].Segment.FunNumber && reg.FunNumber == nil {
+			return nil, errors.New(fmt.Sprintf("register %q (%d) is in several segments with different function codes: %s",
+				name, reg.Address, formatSegmentRefs(refs)))
 		}
 	}
 
-	return []Segment{}
+	loc.Segment = refs[0].Segment
+	loc.Group = refs[0].Group
+
+	return &loc, nil
+}
+
+func formatSegmentRefs(refs []SegmentRef) string {
+	parts := []string{}
+	for _, ref := range refs {
+		parts = append(parts, fmt.Sprintf("%q at %d (function %d)", ref.Group.Title["base"], ref.Segment.StartAddress, ref.Segment.FunNumber))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (desc *Descriptor) FindRegister(name string) (*Segment, *Register) {
+	loc, err := desc.ResolveRegister(name)
+	if err != nil {
+		return nil, desc.getIndex().registersByName[name]
+	}
+
+	return loc.Segment, loc.Register
+}
+
+func (desc *Descriptor) FindGroup(name string) []Segment {
+	g := desc.getIndex().groupsByName[name]
+
+	if g == nil {
+		return []Segment{}
+	}
+
+	return g.Segments
 }
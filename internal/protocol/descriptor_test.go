@@ -0,0 +1,248 @@
+package protocol
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+func TestOffsetAddressing(t *testing.T) {
+	offset := AddressOffset{
+		OffsetType:    OffsetTypeModbus,
+		OffsetBase:    10000,
+		OffsetAddress: 1,
+	}
+
+	tests := map[uint16]uint16{
+		30001: 0,
+		30010: 9,
+		40201: 200,
+		49023: 9022,
+	}
+
+	for addr, expected := range tests {
+		wire, err := offset.WireAddress(addr)
+		if err != nil {
+			t.Fatalf("failed to translate %d: %s", addr, err)
+		}
+		if wire != expected {
+			t.Fatalf("%d: %d != %d", addr, expected, wire)
+		}
+	}
+
+	if _, err := (AddressOffset{OffsetType: OffsetTypeModbus, OffsetBase: 1000}).WireAddress(40201); err == nil {
+		t.Fatalf("expected an error for address outside of offset base")
+	}
+
+	if _, err := offset.WireAddress(0); err == nil {
+		t.Fatalf("expected an error for negative wire address")
+	}
+
+	wire, err := AddressOffset{}.WireAddress(4501)
+	if err != nil || wire != 4501 {
+		t.Fatalf("%d != %d (%v)", 4501, wire, err)
+	}
+}
+
+func TestLoadOffsetDescriptor(t *testing.T) {
+	desc, err := LoadProtocolDescriptor("../../data/0911.json")
+	if err != nil {
+		t.Fatalf("failed to load descriptor: %s", err)
+	}
+
+	reg := desc.FindRegisterByAddr(40201)
+	if reg == nil || reg.Title["base"] != "Rtc Second" {
+		t.Fatalf("unexpected register %v", reg)
+	}
+
+	if wire, err := desc.WireAddress(reg.Address); err != nil || wire != 200 {
+		t.Fatalf("%d != %d (%v)", 200, wire, err)
+	}
+
+	// "Battery Type" register at 420102 is dropped
+	if len(desc.Root) != 375 {
+		t.Fatalf("expected 375 registers, got %d", len(desc.Root))
+	}
+}
+
+func TestLoadStringByteSort(t *testing.T) {
+	desc, err := LoadProtocolDescriptor("../../data/0975.json")
+	if err != nil {
+		t.Fatalf("failed to load descriptor: %s", err)
+	}
+
+	found := false
+	for _, reg := range desc.Root {
+		if reg.Title["base"] == "Mcu4 Software Ver." {
+			found = true
+			if reg.ByteSort != 2 || reg.FunNumber == nil || *reg.FunNumber != 4 {
+				t.Fatalf("unexpected register %v", reg)
+			}
+		}
+	}
+
+	if !found {
+		t.Fatalf("register with string byteSort not found")
+	}
+}
+
+func TestDescriptorLookups(t *testing.T) {
+	desc := Descriptor{
+		Root: []Register{
+			{Address: 10, Title: map[string]string{"base": "Voltage"}},
+			{Address: 11, Title: map[string]string{"base": "Current"}},
+			{Address: 20, Title: map[string]string{"base": "Mode"}},
+		},
+		Configuration: Configuration{
+			SystemInfoVC: []ConfigurationGroup{
+				{Title: map[string]string{"base": "Info"}, Segments: []Segment{{StartAddress: 10, Length: 2}}},
+			},
+			SystemSettingVC: []ConfigurationGroup{
+				{Title: map[string]string{"base": "Settings"}, Segments: []Segment{{StartAddress: 20, Length: 1}}},
+			},
+		},
+	}
+
+	if reg := desc.FindRegisterByAddr(11); reg != &desc.Root[1] {
+		t.Fatalf("expected pointer to register 11, got %v", reg)
+	}
+
+	seg, reg := desc.FindRegister("Mode")
+	if reg != &desc.Root[2] || seg != &desc.Configuration.SystemSettingVC[0].Segments[0] {
+		t.Fatalf("unexpected lookup result: %v %v", seg, reg)
+	}
+
+	if seg, reg := desc.FindRegister("Power"); seg != nil || reg != nil {
+		t.Fatalf("unexpected lookup result: %v %v", seg, reg)
+	}
+
+	if len(desc.FindSegments(12)) != 0 {
+		t.Fatalf("address 12 is not in any segment")
+	}
+
+	if len(desc.FindGroup("Info")) != 1 {
+		t.Fatalf("failed to find group")
+	}
+}
+
+func TestFunctionLookups(t *testing.T) {
+	holding, input := byte(3), byte(4)
+	desc := Descriptor{
+		Root: []Register{
+			{Address: 10, Title: map[string]string{"base": "Grid charging"}, FunNumber: &holding},
+			{Address: 10, Title: map[string]string{"base": "Device model"}, FunNumber: &input},
+			{Address: 11, Title: map[string]string{"base": "Voltage"}},
+		},
+	}
+
+	if reg := desc.FindRegisterByAddrFun(4, 10); reg != &desc.Root[1] {
+		t.Fatalf("expected input register, got %v", reg)
+	}
+
+	if reg := desc.FindRegisterByAddrFun(3, 10); reg != &desc.Root[0] {
+		t.Fatalf("expected holding register, got %v", reg)
+	}
+
+	if reg := desc.FindRegisterByAddrFun(4, 11); reg != &desc.Root[2] {
+		t.Fatalf("expected register without function code, got %v", reg)
+	}
+
+	regs := desc.BlockRegisters(RegisterBlock{FunNumber: 4, StartAddress: 10, Length: 2})
+	if len(regs) != 2 || regs[0] != &desc.Root[1] {
+		t.Fatalf("unexpected block registers %v", regs)
+	}
+}
+
+func TestResolveRegister(t *testing.T) {
+	fun := byte(3)
+	desc := Descriptor{
+		Root: []Register{
+			{Address: 10, Title: map[string]string{"base": "Voltage"}},
+			{Address: 15, Title: map[string]string{"base": "Power"}},
+			{Address: 30, Title: map[string]string{"base": "Lost"}},
+			{Address: 31, Title: map[string]string{"base": "Own"}, FunNumber: &fun},
+		},
+		Configuration: Configuration{
+			SystemInfoVC: []ConfigurationGroup{
+				{Title: map[string]string{"base": "Info"}, Segments: []Segment{{StartAddress: 10, Length: 10, FunNumber: 4}}},
+			},
+			SystemSettingVC: []ConfigurationGroup{
+				{Title: map[string]string{"base": "Settings"}, Segments: []Segment{{StartAddress: 15, Length: 5, FunNumber: 3}}},
+			},
+		},
+	}
+
+	loc, err := desc.ResolveRegister("Voltage")
+	if err != nil || loc.Segment.FunNumber != 4 || loc.Group.Title["base"] != "Info" {
+		t.Fatalf("unexpected resolution: %+v %v", loc, err)
+	}
+
+	if _, err := desc.ResolveRegister("Power"); err == nil {
+		t.Fatalf("expected an error for register in several segments")
+	}
+
+	if _, err := desc.ResolveRegister("Lost"); err == nil {
+		t.Fatalf("expected an error for register outside of segments")
+	}
+
+	loc, err = desc.ResolveRegister("Own")
+	if err != nil || loc.Segment != nil {
+		t.Fatalf("unexpected resolution: %+v %v", loc, err)
+	}
+
+	// identity registers are outside of segments, the function code of LoopCMDs block is used
+	loaded, err := LoadProtocolDescriptor("../../data/1209.json")
+	if err != nil {
+		t.Fatalf("failed to load descriptor: %s", err)
+	}
+
+	for _, name := range []string{"Manufacturer", "Model", "Software version", "Serial number"} {
+		loc, err := loaded.ResolveRegister(name)
+		if err != nil || loc.Segment.FunNumber != 4 || loc.Group != nil {
+			t.Fatalf("unexpected resolution of %s: %+v %v", name, loc, err)
+		}
+	}
+}
+
+func TestBlockRegisters(t *testing.T) {
+	two := 2
+	desc := Descriptor{
+		Root: []Register{
+			{Address: 0, Title: map[string]string{"base": "A"}},
+			{Address: 2, Length: &two, Title: map[string]string{"base": "B"}},
+			{Address: 4, Length: &two, Title: map[string]string{"base": "C"}},
+		},
+	}
+
+	var conf Configuration
+	data := `{"LoopCMDs": [{"funNumber": "4", "startAddress": 0, "length": 5}]}`
+	if err := json.Unmarshal([]byte(data), &conf); err != nil {
+		t.Fatalf("failed to parse LoopCMDs: %s", err)
+	}
+
+	expected := RegisterBlock{FunNumber: 4, StartAddress: 0, Length: 5}
+	if len(conf.LoopCMDs) != 1 || conf.LoopCMDs[0] != expected {
+		t.Fatalf("unexpected LoopCMDs: %+v", conf.LoopCMDs)
+	}
+
+	regs := desc.BlockRegisters(conf.LoopCMDs[0])
+	if len(regs) != 2 || regs[0] != &desc.Root[0] || regs[1] != &desc.Root[1] {
+		t.Fatalf("unexpected block registers: %v", regs)
+	}
+}
+
+func TestConcurrentLookups(t *testing.T) {
+	desc := Descriptor{Root: []Register{{Address: 10}}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if desc.FindRegisterByAddr(10) == nil {
+				t.Errorf("register not found")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,367 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+type LintIssue struct {
+	Address *uint16
+	Message string
+}
+
+func (issue LintIssue) String() string {
+	if issue.Address == nil {
+		return issue.Message
+	}
+	return fmt.Sprintf("register %d: %s", *issue.Address, issue.Message)
+}
+
+var knownDescriptorKeys = []string{
+	"ProtoName", "ProtoVersion", "Root", "Configuration", "OtherCodes",
+}
+
+var knownConfigurationKeys = []string{
+	"devAddrs", "SystemSettingVC", "SystemInfoVC", "SystemFlowVC", "FlowInfoVC", "LoopCMDs",
+	"writeMoreFunCode", "writeOneFunCode", "addressOffset",
+}
+
+var knownSegmentKeys = []string{
+	"canEdit", "length", "funNumber", "startAddress",
+}
+
+var knownRegisterKeys = []string{
+	"address", "byteSort", "length", "title", "subTitle", "iconName", "enumerationStrings",
+	"rangeEnumerationStrings", "stepEnumeration", "subModels", "valueType", "versionType",
+	"units", "scale", "offset", "digits", "funNumber", "bcdType", "spaceMark",
+}
+
+// Loads descriptor file strictly and reports every problem found in it.
+// An empty result means the descriptor is fine.
+func LintProtocolDescriptor(path string) []LintIssue {
+	issues := []LintIssue{}
+
+	report := func(addr *uint16, format string, args ...any) {
+		issues = append(issues, LintIssue{Address: addr, Message: fmt.Sprintf(format, args...)})
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		report(nil, "%s", err)
+		return issues
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		report(nil, "%s", err)
+		return issues
+	}
+
+	if bytes.HasPrefix(data, byteOrderMark) {
+		report(nil, "file starts with UTF-8 BOM")
+	}
+
+	issues = append(issues, lintKeys(bytes.TrimPrefix(data, byteOrderMark))...)
+
+	desc, err := LoadProtocolDescriptor(path)
+	if err != nil {
+		report(nil, "failed to load descriptor: %s", err)
+
+		// the rest of the file is still checked to report all problems at once
+		desc = lintDecode(bytes.TrimPrefix(data, byteOrderMark), report)
+		if desc == nil {
+			return issues
+		}
+	}
+
+	segments := []Segment{}
+	for _, g := range desc.Configuration.SystemInfoVC {
+		segments = append(segments, g.Segments...)
+	}
+	for _, g := range desc.Configuration.SystemSettingVC {
+		segments = append(segments, g.Segments...)
+	}
+
+	if len(desc.Configuration.DevAddrs) == 0 {
+		report(nil, "no device addresses (devAddrs) specified")
+	}
+
+	for _, s := range segments {
+		if _, err := desc.WireAddress(s.StartAddress); err != nil {
+			report(nil, "segment %d: %s", s.StartAddress, err)
+		}
+	}
+
+	// registers of different function codes may share addresses (e.g. in 0975)
+	seen := make(map[registerKey]bool)
+
+	for i := range desc.Root {
+		reg := &desc.Root[i]
+		addr := reg.Address
+
+		key := registerKey{funNumber: effectiveFunNumber(desc, reg), addr: addr}
+		if seen[key] {
+			report(&addr, "duplicate register address")
+		}
+		seen[key] = true
+
+		if _, err := desc.WireAddress(addr); err != nil {
+			report(&addr, "%s", err)
+		}
+
+		if reg.Title["base"] == "" {
+			report(&addr, "register has no base title")
+		}
+
+		refs := desc.LocateRegister(reg)
+
+		if len(refs) == 0 && reg.FunNumber == nil && desc.FindLoopBlock(reg) == nil {
+			report(&addr, "register %q is outside of any segment", reg.Title["base"])
+		}
+
+		for _, ref := range refs[min(len(refs), 1):] {
+			if ref.Segment.FunNumber != refs[0].Segment.FunNumber && reg.FunNumber == nil {
+				report(&addr, "register %q is in several segments with different function codes: %s", reg.Title["base"], formatSegmentRefs(refs))
+				break
+			}
+		}
+
+		if reg.EnumerationStrings != nil && reg.EnumerationStrings.External != nil {
+			if _, ok := desc.OtherCodes[*reg.EnumerationStrings.External]; !ok {
+				report(&addr, "missing external enum %q", *reg.EnumerationStrings.External)
+			}
+		}
+
+		if reg.SubModels != nil {
+			if bitfield, ok := desc.OtherCodes[*reg.SubModels]; !ok || len(bitfield.Fields) == 0 {
+				report(&addr, "missing bitfield %q", *reg.SubModels)
+			}
+		}
+	}
+
+	return issues
+}
+
+// Decodes registers, external enums and configuration separately, skipping and reporting
+// the malformed ones. Returns nil if the descriptor can't be decoded at all.
+func lintDecode(data []byte, report func(addr *uint16, format string, args ...any)) *Descriptor {
+	var raw struct {
+		Root          []json.RawMessage
+		Configuration json.RawMessage
+		OtherCodes    map[string]json.RawMessage
+	}
+
+	if json.Unmarshal(data, &raw) != nil {
+		return nil
+	}
+
+	desc := Descriptor{OtherCodes: make(map[string]ExternEnum)}
+
+	if raw.Configuration != nil {
+		if err := json.Unmarshal(raw.Configuration, &desc.Configuration); err != nil {
+			report(nil, "configuration: %s", err)
+		}
+	}
+
+	names := make([]string, 0, len(raw.OtherCodes))
+	for name := range raw.OtherCodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var enum ExternEnum
+		if err := json.Unmarshal(raw.OtherCodes[name], &enum); err != nil {
+			report(nil, "external enum %q: %s", name, err)
+			continue
+		}
+		desc.OtherCodes[name] = enum
+	}
+
+	for _, r := range raw.Root {
+		var reg Register
+		if err := json.Unmarshal(r, &reg); err != nil {
+			var parsed struct {
+				Address *int64
+			}
+
+			json.Unmarshal(r, &parsed)
+
+			if parsed.Address != nil && *parsed.Address >= 0 && *parsed.Address <= math.MaxUint16 {
+				addr := uint16(*parsed.Address)
+				report(&addr, "%s", err)
+			} else {
+				report(nil, "register: %s", err)
+			}
+			continue
+		}
+
+		desc.Root = append(desc.Root, reg)
+	}
+
+	if err := desc.prepare(); err != nil {
+		report(nil, "%s", err)
+		return nil
+	}
+
+	return &desc
+}
+
+// Returns the function code the register is read with, 0 if it's unknown
+func effectiveFunNumber(desc *Descriptor, reg *Register) byte {
+	if reg.FunNumber != nil {
+		return *reg.FunNumber
+	}
+
+	if refs := desc.LocateRegister(reg); len(refs) > 0 {
+		return refs[0].Segment.FunNumber
+	}
+
+	if block := desc.FindLoopBlock(reg); block != nil {
+		return block.FunNumber
+	}
+
+	return 0
+}
+
+func lintKeys(data []byte) []LintIssue {
+	issues := []LintIssue{}
+
+	var root map[string]json.RawMessage
+	if json.Unmarshal(data, &root) != nil {
+		// syntax errors are reported by the loader
+		return issues
+	}
+
+	issues = append(issues, checkKeys(nil, "", root, knownDescriptorKeys)...)
+
+	var conf map[string]json.RawMessage
+	if raw, ok := lookupKey(root, "Configuration"); ok && json.Unmarshal(raw, &conf) == nil {
+		issues = append(issues, checkKeys(nil, "configuration: ", conf, knownConfigurationKeys)...)
+
+		for _, name := range []string{"SystemInfoVC", "SystemSettingVC"} {
+			var groups []struct {
+				Segments []map[string]json.RawMessage
+			}
+
+			raw, ok := lookupKey(conf, name)
+			if !ok || json.Unmarshal(raw, &groups) != nil {
+				continue
+			}
+
+			for _, g := range groups {
+				for _, s := range g.Segments {
+					issues = append(issues, checkKeys(nil, name+" segment: ", s, knownSegmentKeys)...)
+				}
+			}
+		}
+	}
+
+	var regs []map[string]json.RawMessage
+	if raw, ok := lookupKey(root, "Root"); ok && json.Unmarshal(raw, &regs) == nil {
+		for _, r := range regs {
+			var addr *uint16
+			var a int64
+			if raw, ok := lookupKey(r, "address"); ok && json.Unmarshal(raw, &a) == nil {
+				if a < 0 || a > math.MaxUint16 {
+					issues = append(issues, LintIssue{Message: fmt.Sprintf("register %d: address is out of range, register is ignored", a)})
+				} else {
+					a16 := uint16(a)
+					addr = &a16
+				}
+			}
+
+			issues = append(issues, checkKeys(addr, "", r, knownRegisterKeys)...)
+		}
+	}
+
+	return issues
+}
+
+func lookupKey(obj map[string]json.RawMessage, key string) (json.RawMessage, bool) {
+	for k, v := range obj {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func checkKeys(addr *uint16, prefix string, obj map[string]json.RawMessage, known []string) []LintIssue {
+	issues := []LintIssue{}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		isKnown := false
+		for _, kk := range known {
+			if strings.EqualFold(k, kk) {
+				isKnown = true
+				break
+			}
+		}
+
+		if isKnown {
+			continue
+		}
+
+		msg := fmt.Sprintf("%sunknown key %q", prefix, k)
+		if s := suggestKey(k, known); s != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", s)
+		}
+
+		issues = append(issues, LintIssue{Address: addr, Message: msg})
+	}
+
+	return issues
+}
+
+// Returns a known key which is a likely misspelling of key
+func suggestKey(key string, known []string) string {
+	best := ""
+	bestDist := 3
+
+	for _, k := range known {
+		d := editDistance(strings.ToLower(key), strings.ToLower(k))
+		if d < bestDist {
+			best = k
+			bestDist = d
+		}
+	}
+
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
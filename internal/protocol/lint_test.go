@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintDescriptor(t *testing.T) {
+	descr := "\xef\xbb\xbf" + `{
+		"Root": [
+			{"address": 10, "byteSoft": 1, "valueType": 1, "title": {"base": "Voltage"}, "scale": 1},
+			{"address": 11, "valueType": 1, "title": {"base": "Mode"}, "enumerationStrings": "Modes", "scale": 1},
+			{"address": 40, "valueType": 1, "title": {"base": "Lost"}, "scale": 1}
+		],
+		"OherCodes": {},
+		"Configuration": {
+			"devAddrs": ["1"],
+			"SystemInfoVC": [{"title": {"base": "Info"}, "segments": [{"canEdit": false, "length": 2, "funNumber": "4", "startAddress": 10}]}]
+		}
+	}`
+
+	path := filepath.Join(t.TempDir(), "0000.json")
+	if err := os.WriteFile(path, []byte(descr), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	issues := LintProtocolDescriptor(path)
+
+	expected := []string{
+		"file starts with UTF-8 BOM",
+		`unknown key "OherCodes" (did you mean "OtherCodes"?)`,
+		`register 10: unknown key "byteSoft" (did you mean "byteSort"?)`,
+		`register 11: missing external enum "Modes"`,
+		`register 40: register "Lost" is outside of any segment`,
+	}
+
+	var lines []string
+	for _, issue := range issues {
+		lines = append(lines, issue.String())
+	}
+
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected lint result:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestLintMalformedDescriptor(t *testing.T) {
+	descr := `{
+		"Root": [
+			{"address": 10, "valueType": 1, "title": {"base": "Model"}, "enumerationStrings": {"base": {"GCL 5.6KWH": "GCL"}}, "scale": 1},
+			{"address": 11, "valueType": 1, "title": {"base": "Mode"}, "enumerationStrings": "Modes", "scale": 1},
+			{"address": 40, "valueType": 1, "title": {"base": "Lost"}, "scale": 1}
+		],
+		"OtherCodes": {"Modes": {"base": {"Default": "Default"}}},
+		"Configuration": {
+			"devAddrs": ["1"],
+			"SystemInfoVC": [{"title": {"base": "Info"}, "segments": [{"canEdit": false, "length": 2, "funNumber": "4", "startAddress": 10}]}]
+		}
+	}`
+
+	path := filepath.Join(t.TempDir(), "0000.json")
+	if err := os.WriteFile(path, []byte(descr), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for _, issue := range LintProtocolDescriptor(path) {
+		lines = append(lines, issue.String())
+	}
+
+	expected := []string{
+		`external enum "Modes": `,
+		`register 10: `,
+		`register 11: missing external enum "Modes"`,
+		`register 40: register "Lost" is outside of any segment`,
+	}
+
+	if len(lines) != len(expected)+1 || !strings.HasPrefix(lines[0], "failed to load descriptor: ") {
+		t.Fatalf("unexpected lint result:\n%s", strings.Join(lines, "\n"))
+	}
+
+	for i, prefix := range expected {
+		if !strings.HasPrefix(lines[i+1], prefix) {
+			t.Fatalf("unexpected lint result:\n%s", strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func TestLintSharedAddresses(t *testing.T) {
+	// 0975 has input and holding registers at the same addresses
+	if issues := LintProtocolDescriptor("../../data/0975.json"); len(issues) != 0 {
+		t.Fatalf("unexpected lint result: %v", issues)
+	}
+}